@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// AtomicUserData is an alternative to UserData for hot experience counters.
+// Experience is backed by sync/atomic so granting XP never takes the mutex,
+// the other fields are still guarded by mu.
+//
+// The tradeoff is consistency: GameLevel is only recomputed by SyncGameLevel,
+// so Experience and GameLevel may briefly disagree
+type AtomicUserData struct {
+	experience  int64 // accessed only via sync/atomic, kept first for 64-bit alignment
+	mu          sync.RWMutex
+	UserId      string
+	DisplayName string
+	GameLevel   int
+}
+
+func NewAtomicUserData(userId string, displayName string, gameLevel int, experience int64) *AtomicUserData {
+	return &AtomicUserData{
+		experience:  experience,
+		UserId:      userId,
+		DisplayName: displayName,
+		GameLevel:   gameLevel,
+	}
+}
+
+// AddExperienceAtomic grants experience without locking and returns the new value
+func (u *AtomicUserData) AddExperienceAtomic(delta int64) int64 {
+	return atomic.AddInt64(&u.experience, delta)
+}
+
+func (u *AtomicUserData) GetExperienceAtomic() int64 {
+	return atomic.LoadInt64(&u.experience)
+}
+
+func (u *AtomicUserData) GetDisplayName() string {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.DisplayName
+}
+
+func (u *AtomicUserData) SetDisplayName(displayName string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.DisplayName = displayName
+}
+
+func (u *AtomicUserData) GetGameLevel() int {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.GameLevel
+}
+
+// SyncGameLevel recomputes GameLevel from the current experience and returns it
+func (u *AtomicUserData) SyncGameLevel() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.GameLevel = LevelCurve(atomic.LoadInt64(&u.experience))
+	return u.GameLevel
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func BenchmarkAddExperienceLocked(b *testing.B) {
+	userData := NewUserData("uid_001", "king", 0, 0)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			userData.AddExperience(1)
+		}
+	})
+}
+
+func BenchmarkAddExperienceAtomic(b *testing.B) {
+	userData := NewAtomicUserData("uid_001", "king", 0, 0)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			userData.AddExperienceAtomic(1)
+		}
+	})
+}
@@ -0,0 +1,12 @@
+package main
+
+// Cache is the core of UsersCache that consumers can depend on and replace with a fake in tests
+type Cache interface {
+	GetUserData(userId string) (*UserData, bool)
+	AddUserData(users ...*UserData)
+	RemoveUserData(userId string) bool
+	UpdateUserData(userId string, operation func(userData *UserData)) bool
+	Len() int
+}
+
+var _ Cache = (*UsersCache)(nil)
@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+// fakeCache is a minimal unsynchronized Cache for single-goroutine tests
+type fakeCache struct {
+	users map[string]*UserData
+}
+
+func (f *fakeCache) GetUserData(userId string) (*UserData, bool) {
+	userData, found := f.users[userId]
+	return userData, found
+}
+
+func (f *fakeCache) AddUserData(users ...*UserData) {
+	for _, user := range users {
+		f.users[user.UserId] = user
+	}
+}
+
+func (f *fakeCache) RemoveUserData(userId string) bool {
+	_, found := f.users[userId]
+	delete(f.users, userId)
+	return found
+}
+
+func (f *fakeCache) UpdateUserData(userId string, operation func(userData *UserData)) bool {
+	userData, found := f.users[userId]
+	if found {
+		operation(userData)
+	}
+	return found
+}
+
+func (f *fakeCache) Len() int {
+	return len(f.users)
+}
+
+func TestLoadUsersDataFromDBIntoFakeCache(t *testing.T) {
+	cache := &fakeCache{users: make(map[string]*UserData)}
+	if err := LoadUsersDataFromDB(cache, MockUserSource{}); err != nil {
+		t.Fatalf("LoadUsersDataFromDB: %v", err)
+	}
+	if got := cache.Len(); got != 4 {
+		t.Fatalf("Len = %d, want 4", got)
+	}
+	if !cache.UpdateUserData("uid_001", func(u *UserData) { u.Experience = 150 }) {
+		t.Fatal("UpdateUserData did not find uid_001")
+	}
+	if userData, _ := cache.GetUserData("uid_001"); userData.Experience != 150 {
+		t.Fatalf("experience = %d, want 150", userData.Experience)
+	}
+}
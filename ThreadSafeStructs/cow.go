@@ -0,0 +1,43 @@
+package main
+
+// Snapshot returns the users as they were after the latest add, remove, eviction, Clear
+// or Drain. With WithCopyOnWriteSnapshot the map is rebuilt before those writers release
+// the lock and loaded here without locking, so readers never wait, and it is shared
+// between callers: treat it as read-only. Field updates on cached users only show up
+// once a later add or remove rebuilds it. Without the option a fresh map is built under
+// the read lock
+func (uc *UsersCache) Snapshot() map[string]UserData {
+	if uc.copyOnWrite {
+		return *uc.snapshot.Load()
+	}
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+	return uc.buildSnapshotLocked()
+}
+
+// unlock releases the write lock, first republishing the snapshot if an insert or
+// delete changed the users since it was built. Every write-locked method unlocks here
+func (uc *UsersCache) unlock() {
+	if uc.snapshotDirty {
+		uc.publishSnapshotLocked()
+	}
+	uc.mu.Unlock()
+}
+
+// publishSnapshotLocked rebuilds the copy-on-write snapshot if enabled, caller holds uc.mu
+func (uc *UsersCache) publishSnapshotLocked() {
+	uc.snapshotDirty = false
+	if !uc.copyOnWrite {
+		return
+	}
+	snapshot := uc.buildSnapshotLocked()
+	uc.snapshot.Store(&snapshot)
+}
+
+func (uc *UsersCache) buildSnapshotLocked() map[string]UserData {
+	snapshot := make(map[string]UserData, len(uc.userDataById))
+	for userId, userData := range uc.userDataById {
+		snapshot[userId] = userData.CopyValue()
+	}
+	return snapshot
+}
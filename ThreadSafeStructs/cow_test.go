@@ -0,0 +1,130 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshotCopyOnWrite(t *testing.T) {
+	usersCache := NewUsersCacheWithOptions(WithCopyOnWriteSnapshot())
+	if err := LoadUsersDataFromDB(usersCache, MockUserSource{}); err != nil {
+		t.Fatalf("LoadUsersDataFromDB: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			userId := "uid_" + strconv.Itoa(100+i)
+			usersCache.AddUserData(NewUserData(userId, "knight", 0, int64(i)))
+			usersCache.RemoveUserData(userId)
+		}
+	}()
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				snapshot := usersCache.Snapshot()
+				if n := len(snapshot); n != 4 && n != 5 {
+					t.Errorf("snapshot has %d users, want 4 or 5", n)
+					return
+				}
+				if snapshot["uid_001"].Experience != 100 {
+					t.Errorf("uid_001 experience = %d, want 100", snapshot["uid_001"].Experience)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := len(usersCache.Snapshot()); got != 4 {
+		t.Fatalf("final snapshot has %d users, want 4", got)
+	}
+	usersCache.Clear()
+	if got := len(usersCache.Snapshot()); got != 0 {
+		t.Fatalf("snapshot after Clear has %d users, want 0", got)
+	}
+}
+
+func TestSnapshotWithoutCopyOnWrite(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	snapshot := usersCache.Snapshot()
+	if len(snapshot) != 4 || snapshot["uid_003"].Experience != 120 {
+		t.Fatalf("snapshot has %d users, uid_003 experience %d", len(snapshot), snapshot["uid_003"].Experience)
+	}
+}
+
+func TestSnapshotCopyOnWriteOtherWritePaths(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(uc *UsersCache)
+		want   []string
+	}{
+		{
+			name:   "TryAddUserData",
+			mutate: func(uc *UsersCache) { uc.TryAddUserData(NewUserData("uid_002", "queen", 1, 110)) },
+			want:   []string{"uid_001", "uid_002"},
+		},
+		{
+			name: "GetOrCreate",
+			mutate: func(uc *UsersCache) {
+				uc.GetOrCreate("uid_002", func() *UserData { return NewUserData("uid_002", "queen", 1, 110) })
+			},
+			want: []string{"uid_001", "uid_002"},
+		},
+		{
+			name:   "SetUserId",
+			mutate: func(uc *UsersCache) { uc.SetUserId("uid_001", "uid_100") },
+			want:   []string{"uid_100"},
+		},
+		{
+			name:   "EvictIdle",
+			mutate: func(uc *UsersCache) { uc.EvictIdle(-time.Hour) },
+			want:   []string{},
+		},
+		{
+			name:   "ApplyIfNewer",
+			mutate: func(uc *UsersCache) { uc.ApplyIfNewer(NewUserData("uid_002", "queen", 1, 110)) },
+			want:   []string{"uid_001", "uid_002"},
+		},
+		{
+			name: "ImportJSONMerge",
+			mutate: func(uc *UsersCache) {
+				uc.ImportJSONMerge([]byte(`[{"uid":"uid_002","display_name":"queen","game_level":1,"experience":110}]`))
+			},
+			want: []string{"uid_001", "uid_002"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usersCache := NewUsersCacheWithOptions(WithCopyOnWriteSnapshot())
+			usersCache.AddUserData(NewUserData("uid_001", "king", 1, 100))
+			tt.mutate(usersCache)
+			snapshot := usersCache.Snapshot()
+			got := make([]string, 0, len(snapshot))
+			for userId := range snapshot {
+				got = append(got, userId)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("snapshot ids = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapshotCopyOnWriteLRUEviction(t *testing.T) {
+	usersCache := NewUsersCacheWithOptions(WithCopyOnWriteSnapshot(), WithLRU(2))
+	usersCache.AddUserData(NewUserData("uid_001", "king", 1, 100), NewUserData("uid_002", "queen", 1, 110))
+	usersCache.SetMaxUsers(1)
+	if got := len(usersCache.Snapshot()); got != 1 {
+		t.Fatalf("snapshot after SetMaxUsers(1) has %d users, want 1", got)
+	}
+}
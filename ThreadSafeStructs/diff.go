@@ -0,0 +1,67 @@
+package main
+
+import "sort"
+
+// FieldChange is one field of a user that differs between two snapshots,
+// Field is the JSON field name
+type FieldChange struct {
+	UserId string
+	Field  string
+	Before interface{}
+	After  interface{}
+}
+
+// CacheDiff lists what changed between two snapshots, all lists are sorted by UserId
+type CacheDiff struct {
+	Added   []string
+	Removed []string
+	Changed []FieldChange
+}
+
+// DiffSnapshots compares two snapshots such as GetSnapshot results by UserId
+func DiffSnapshots(before, after []UserData) CacheDiff {
+	beforeById := snapshotIndex(before)
+	afterById := snapshotIndex(after)
+
+	var diff CacheDiff
+	for userId := range beforeById {
+		if _, found := afterById[userId]; !found {
+			diff.Removed = append(diff.Removed, userId)
+		}
+	}
+	for userId, newer := range afterById {
+		older, found := beforeById[userId]
+		if !found {
+			diff.Added = append(diff.Added, userId)
+			continue
+		}
+		diff.Changed = append(diff.Changed, diffUser(older, newer)...)
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.SliceStable(diff.Changed, func(i, j int) bool { return diff.Changed[i].UserId < diff.Changed[j].UserId })
+	return diff
+}
+
+func snapshotIndex(snapshot []UserData) map[string]*UserData {
+	byId := make(map[string]*UserData, len(snapshot))
+	for i := range snapshot {
+		byId[snapshot[i].UserId] = &snapshot[i]
+	}
+	return byId
+}
+
+// diffUser compares the data fields of two snapshot copies, in declaration order
+func diffUser(older, newer *UserData) []FieldChange {
+	var changes []FieldChange
+	add := func(field string, before, after interface{}) {
+		if before != after {
+			changes = append(changes, FieldChange{UserId: newer.UserId, Field: field, Before: before, After: after})
+		}
+	}
+	add("display_name", older.DisplayName, newer.DisplayName)
+	add("game_level", older.GameLevel, newer.GameLevel)
+	add("experience", older.Experience, newer.Experience)
+	add("internal_data", older.UserInternalData, newer.UserInternalData)
+	return changes
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	before := usersCache.GetSnapshot()
+
+	usersCache.AddUserData(NewUserData("uid_005", "knight", 0, 0))
+	usersCache.RemoveUserData("uid_004")
+	usersCache.WithUser("uid_002", func(u *UserData) { u.AddExperience(5) })
+	after := usersCache.GetSnapshot()
+
+	want := CacheDiff{
+		Added:   []string{"uid_005"},
+		Removed: []string{"uid_004"},
+		Changed: []FieldChange{
+			{UserId: "uid_002", Field: "experience", Before: int64(110), After: int64(115)},
+		},
+	}
+	if got := DiffSnapshots(before, after); !reflect.DeepEqual(got, want) {
+		t.Fatalf("DiffSnapshots = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,11 @@
+package main
+
+import "errors"
+
+// Errors returned wrapped by cache operations, match them with errors.Is
+var (
+	ErrUserNotFound           = errors.New("user not found")
+	ErrUserExists             = errors.New("user already exists")
+	ErrInsufficientExperience = errors.New("insufficient experience")
+	ErrCacheFull              = errors.New("cache is full")
+)
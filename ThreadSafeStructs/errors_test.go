@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSentinelErrors(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	_, errDuplicate := usersCache.AddUserDataUnique(NewUserData("uid_003", "impostor", 0, 0))
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{name: "SetUserId missing", err: usersCache.SetUserId("uid_404", "uid_405"), want: ErrUserNotFound},
+		{name: "SetUserId taken", err: usersCache.SetUserId("uid_001", "uid_002"), want: ErrUserExists},
+		{name: "Transfer missing", err: usersCache.Transfer("uid_001", "uid_404", 1), want: ErrUserNotFound},
+		{name: "Transfer insufficient", err: usersCache.Transfer("uid_001", "uid_002", 1000), want: ErrInsufficientExperience},
+		{name: "AddUserDataUnique", err: errDuplicate, want: ErrUserExists},
+	}
+
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.want) {
+			t.Errorf("%s: err = %v, want errors.Is %v", tt.name, tt.err, tt.want)
+		}
+	}
+}
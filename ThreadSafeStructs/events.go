@@ -0,0 +1,75 @@
+package main
+
+import "sync"
+
+type CacheEventType int
+
+const (
+	CacheEventAdd CacheEventType = iota
+	CacheEventRemove
+)
+
+func (t CacheEventType) String() string {
+	switch t {
+	case CacheEventAdd:
+		return "add"
+	case CacheEventRemove:
+		return "remove"
+	default:
+		return "unknown"
+	}
+}
+
+// CacheEvent reports a membership change published by every method that adds or
+// removes users, see SetLogger. Evictions and ReplayWAL are not published
+type CacheEvent struct {
+	Type   CacheEventType
+	UserId string
+}
+
+// EventBufferSize is the capacity of each subscription channel, events published
+// while the buffer is full are dropped for that subscriber
+const EventBufferSize = 64
+
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan CacheEvent]struct{}
+}
+
+// Subscribe returns a channel of membership events and a function that cancels the
+// subscription and closes the channel. Publishing never blocks, a subscriber that
+// falls more than EventBufferSize events behind misses events.
+// Events are published after the cache lock is released, so receivers may call back into the cache
+func (uc *UsersCache) Subscribe() (<-chan CacheEvent, func()) {
+	events := make(chan CacheEvent, EventBufferSize)
+	uc.events.mu.Lock()
+	if uc.events.subscribers == nil {
+		uc.events.subscribers = make(map[chan CacheEvent]struct{})
+	}
+	uc.events.subscribers[events] = struct{}{}
+	uc.events.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			uc.events.mu.Lock()
+			defer uc.events.mu.Unlock()
+			delete(uc.events.subscribers, events)
+			close(events)
+		})
+	}
+	return events, unsubscribe
+}
+
+func (h *eventHub) publish(eventType CacheEventType, userIds ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for events := range h.subscribers {
+		for _, userId := range userIds {
+			select {
+			case events <- CacheEvent{Type: eventType, UserId: userId}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	usersCache := NewUsersCache()
+	events, unsubscribe := usersCache.Subscribe()
+	defer unsubscribe()
+
+	usersCache.AddUserData(NewUserData("uid_001", "king", 1, 100))
+	usersCache.RemoveUserData("uid_001")
+
+	want := []CacheEvent{
+		{Type: CacheEventAdd, UserId: "uid_001"},
+		{Type: CacheEventRemove, UserId: "uid_001"},
+	}
+	for _, wantEvent := range want {
+		select {
+		case event := <-events:
+			if event != wantEvent {
+				t.Fatalf("event = %+v, want %+v", event, wantEvent)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %+v", wantEvent)
+		}
+	}
+}
+
+func TestUnsubscribeClosesChannel(t *testing.T) {
+	usersCache := NewUsersCache()
+	events, unsubscribe := usersCache.Subscribe()
+	unsubscribe()
+	unsubscribe()
+
+	usersCache.AddUserData(NewUserData("uid_001", "king", 1, 100))
+	if _, open := <-events; open {
+		t.Fatal("channel still open after unsubscribe")
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// jsonSnapshotLocked copies every user's fields under that user's read lock,
+// sorted by UserId so exports are stable. Caller holds uc.mu
+func (uc *UsersCache) jsonSnapshotLocked() []userDataJSON {
+	res := make([]userDataJSON, 0, len(uc.userDataById))
+	for _, userData := range uc.userDataById {
+		res = append(res, userData.jsonSnapshot())
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].UserId < res[j].UserId })
+	return res
+}
+
+// ExportJSON dumps the whole cache as a JSON array of users
+func (uc *UsersCache) ExportJSON() ([]byte, error) {
+	uc.mu.RLock()
+	snapshot := uc.jsonSnapshotLocked()
+	uc.mu.RUnlock()
+	return json.Marshal(snapshot)
+}
+
+// publicUserJSON is the projection of a user that is safe to serve publicly
+type publicUserJSON struct {
+	UserId      string `json:"uid"`
+	DisplayName string `json:"display_name"`
+	GameLevel   int    `json:"game_level"`
+}
+
+// ExportPublicJSON is ExportJSON limited to uid, display_name and game_level, it
+// leaves out experience and internal data
+func (uc *UsersCache) ExportPublicJSON() ([]byte, error) {
+	uc.mu.RLock()
+	snapshot := uc.jsonSnapshotLocked()
+	uc.mu.RUnlock()
+	public := make([]publicUserJSON, len(snapshot))
+	for i, entry := range snapshot {
+		public[i] = publicUserJSON{UserId: entry.UserId, DisplayName: entry.DisplayName, GameLevel: entry.GameLevel}
+	}
+	return json.Marshal(public)
+}
+
+func parseUsersJSON(data []byte) ([]*UserData, error) {
+	var snapshot []userDataJSON
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("parse users json: %w", err)
+	}
+	users := make([]*UserData, len(snapshot))
+	for i, entry := range snapshot {
+		users[i] = entry.userData()
+		if err := users[i].Validate(); err != nil {
+			return nil, fmt.Errorf("users json entry %d: %w", i, err)
+		}
+	}
+	return users, nil
+}
+
+// ImportJSON loads a JSON array produced by ExportJSON. Imported users overwrite
+// cached users with the same UserId, see ImportJSONMerge to keep existing ones.
+// Nothing is inserted if data can't be parsed or any entry fails Validate, the error
+// names the first invalid entry
+func (uc *UsersCache) ImportJSON(data []byte) error {
+	users, err := parseUsersJSON(data)
+	if err != nil {
+		return err
+	}
+	uc.AddUserData(users...)
+	return nil
+}
+
+// ImportJSONMerge is ImportJSON that skips users whose UserId is already cached
+func (uc *UsersCache) ImportJSONMerge(data []byte) error {
+	users, err := parseUsersJSON(data)
+	if err != nil {
+		return err
+	}
+	var m mutations
+	uc.mu.Lock()
+	for _, user := range users {
+		if _, exists := uc.userDataById[user.UserId]; !exists {
+			uc.addLocked(&m, user.UserId, user)
+		}
+	}
+	uc.unlockAndReport(&m)
+	return nil
+}
+
+// LoadJSONMap loads a JSON object of users keyed by UserId, e.g. {"uid_001": {...}}.
+// Loaded users overwrite cached ones like ImportJSON. Every entry's uid must match its
+// key, otherwise nothing is inserted and the error names the first mismatching key
+func (uc *UsersCache) LoadJSONMap(data []byte) error {
+	var entries map[string]userDataJSON
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("parse users json map: %w", err)
+	}
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	users := make([]*UserData, 0, len(keys))
+	for _, key := range keys {
+		entry := entries[key]
+		if entry.UserId != key {
+			return fmt.Errorf("users json map: key %q holds uid %q", key, entry.UserId)
+		}
+		users = append(users, entry.userData())
+	}
+	uc.AddUserData(users...)
+	return nil
+}
+
+var csvHeader = []string{"uid", "display_name", "game_level", "experience"}
+
+// ExportCSV writes the cache as CSV with a header row, each user is read under its lock
+func (uc *UsersCache) ExportCSV(w io.Writer) error {
+	uc.mu.RLock()
+	snapshot := uc.jsonSnapshotLocked()
+	uc.mu.RUnlock()
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, entry := range snapshot {
+		record := []string{
+			entry.UserId,
+			entry.DisplayName,
+			strconv.Itoa(entry.GameLevel),
+			strconv.FormatInt(entry.Experience, 10),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// ImportCSV loads users written by ExportCSV, overwriting cached users with the same
+// UserId like ImportJSON. Nothing is inserted if any row is invalid
+func (uc *UsersCache) ImportCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(csvHeader)
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("read csv header: %w", err)
+	}
+	for i, column := range csvHeader {
+		if header[i] != column {
+			return fmt.Errorf("csv header column %d is %q, want %q", i+1, header[i], column)
+		}
+	}
+
+	var users []*UserData
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read csv: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		gameLevel, err := strconv.Atoi(record[2])
+		if err != nil {
+			return fmt.Errorf("csv line %d: invalid game_level %q", line, record[2])
+		}
+		experience, err := strconv.ParseInt(record[3], 10, 64)
+		if err != nil {
+			return fmt.Errorf("csv line %d: invalid experience %q", line, record[3])
+		}
+		users = append(users, NewUserData(record[0], record[1], gameLevel, experience))
+	}
+	uc.AddUserData(users...)
+	return nil
+}
+
+// StreamJSON writes the same JSON array as ExportJSON one user at a time, so memory
+// use doesn't grow with the encoded size. Users are taken from a pointer snapshot so
+// the cache lock is not held while writing, users removed meanwhile are still written.
+// Unlike ExportJSON the order is not sorted
+func (uc *UsersCache) StreamJSON(w io.Writer) error {
+	users := uc.GetSafeCopySlice()
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	for i, userData := range users {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		if err := encoder.Encode(userData); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]")
+	return err
+}
+
+// SaveToFile writes ExportJSON to a temp file next to path and renames it into place,
+// so a crash leaves either the old file or the complete new one
+func (uc *UsersCache) SaveToFile(path string) error {
+	data, err := uc.ExportJSON()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("save users: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("save users: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("save users: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("save users: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("save users: %w", err)
+	}
+	return nil
+}
+
+// LoadFromFile builds a new cache from a file written by SaveToFile
+func LoadFromFile(path string) (*UsersCache, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("load users from %s: %w", path, err)
+	}
+	usersCache := NewUsersCache()
+	if err := usersCache.ImportJSON(data); err != nil {
+		return nil, fmt.Errorf("load users from %s: %w", path, err)
+	}
+	return usersCache, nil
+}
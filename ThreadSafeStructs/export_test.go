@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportJSON(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	data, err := usersCache.ExportJSON()
+	if err != nil {
+		t.Fatalf("ExportJSON: %v", err)
+	}
+	var users []map[string]interface{}
+	if err := json.Unmarshal(data, &users); err != nil {
+		t.Fatalf("unmarshal export: %v", err)
+	}
+	if len(users) != 4 {
+		t.Fatalf("exported %d users, want 4", len(users))
+	}
+	if users[0]["uid"] != "uid_001" {
+		t.Fatalf("first exported uid = %v, want uid_001", users[0]["uid"])
+	}
+}
+
+const importFixture = `[
+	{"uid":"uid_001","display_name":"kingslayer","game_level":5,"experience":500},
+	{"uid":"uid_010","display_name":"jester","game_level":0,"experience":10}
+]`
+
+func TestExportPublicJSON(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	userData, _ := usersCache.GetUserData("uid_001")
+	userData.SetInternalData("secret")
+
+	data, err := usersCache.ExportPublicJSON()
+	if err != nil {
+		t.Fatalf("ExportPublicJSON: %v", err)
+	}
+	var users []map[string]interface{}
+	if err := json.Unmarshal(data, &users); err != nil {
+		t.Fatalf("unmarshal export: %v", err)
+	}
+	if len(users) != 4 {
+		t.Fatalf("exported %d users, want 4", len(users))
+	}
+	for _, user := range users {
+		if _, found := user["experience"]; found {
+			t.Fatalf("public export has an experience key: %v", user)
+		}
+		if len(user) != 3 {
+			t.Fatalf("public export keys = %v, want only uid, display_name and game_level", user)
+		}
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Fatal("public export leaks internal data")
+	}
+}
+
+func TestImportJSON(t *testing.T) {
+	usersCache := NewUsersCache()
+	if err := usersCache.ImportJSON([]byte(importFixture)); err != nil {
+		t.Fatalf("ImportJSON: %v", err)
+	}
+	if got := usersCache.Len(); got != 2 {
+		t.Fatalf("Len = %d, want 2", got)
+	}
+	jester, found := usersCache.GetUserData("uid_010")
+	if !found || jester.GetDisplayName() != "jester" || jester.GetExperience() != 10 {
+		t.Fatalf("uid_010 = %v, %v", jester, found)
+	}
+
+	if err := usersCache.ImportJSON([]byte(`{"uid":`)); err == nil {
+		t.Fatal("ImportJSON on malformed input returned nil error")
+	}
+}
+
+func TestImportJSONValidation(t *testing.T) {
+	data := `[
+		{"uid":"uid_001","display_name":"king","game_level":1,"experience":100},
+		{"uid":"uid_002","display_name":"queen","game_level":1,"experience":-5}
+	]`
+	usersCache := NewUsersCache()
+	err := usersCache.ImportJSON([]byte(data))
+	if err == nil {
+		t.Fatal("ImportJSON returned nil error for a negative experience")
+	}
+	if !strings.Contains(err.Error(), "entry 1") || !strings.Contains(err.Error(), `"uid_002"`) {
+		t.Fatalf("error %q does not name entry 1 uid_002", err)
+	}
+	if got := usersCache.Len(); got != 0 {
+		t.Fatalf("Len = %d, want 0 after failed import", got)
+	}
+}
+
+func TestImportJSONOverwrite(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	if err := usersCache.ImportJSON([]byte(importFixture)); err != nil {
+		t.Fatalf("ImportJSON: %v", err)
+	}
+	if got := usersCache.Len(); got != 5 {
+		t.Fatalf("Len = %d, want 5", got)
+	}
+	king, _ := usersCache.GetUserData("uid_001")
+	if king.GetDisplayName() != "kingslayer" || king.GetExperience() != 500 {
+		t.Fatalf("uid_001 was not overwritten: %s", king.ToApi())
+	}
+}
+
+func TestImportJSONMerge(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	if err := usersCache.ImportJSONMerge([]byte(importFixture)); err != nil {
+		t.Fatalf("ImportJSONMerge: %v", err)
+	}
+	if got := usersCache.Len(); got != 5 {
+		t.Fatalf("Len = %d, want 5", got)
+	}
+	king, _ := usersCache.GetUserData("uid_001")
+	if king.GetDisplayName() != "king" {
+		t.Fatalf("uid_001 was overwritten: %s", king.ToApi())
+	}
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	var buf bytes.Buffer
+	if err := usersCache.ExportCSV(&buf); err != nil {
+		t.Fatalf("ExportCSV: %v", err)
+	}
+
+	imported := NewUsersCache()
+	if err := imported.ImportCSV(&buf); err != nil {
+		t.Fatalf("ImportCSV: %v", err)
+	}
+	if got := imported.Len(); got != 4 {
+		t.Fatalf("Len = %d, want 4", got)
+	}
+	queen, found := imported.GetUserData("uid_002")
+	if !found || queen.GetDisplayName() != "queen" || queen.GetExperience() != 110 || queen.GetGameLevel() != 1 {
+		t.Fatalf("uid_002 = %v, %v", queen, found)
+	}
+}
+
+func TestImportCSVParseError(t *testing.T) {
+	data := "uid,display_name,game_level,experience\n" +
+		"uid_001,king,1,100\n" +
+		"uid_002,queen,1,lots\n"
+	usersCache := NewUsersCache()
+	err := usersCache.ImportCSV(strings.NewReader(data))
+	if err == nil {
+		t.Fatal("ImportCSV returned nil error for a non-integer experience")
+	}
+	if !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("error %q does not name line 3", err)
+	}
+	if got := usersCache.Len(); got != 0 {
+		t.Fatalf("Len = %d, want 0 after failed import", got)
+	}
+}
+
+func TestStreamJSON(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	var buf bytes.Buffer
+	if err := usersCache.StreamJSON(&buf); err != nil {
+		t.Fatalf("StreamJSON: %v", err)
+	}
+	var users []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &users); err != nil {
+		t.Fatalf("unmarshal stream: %v\n%s", err, buf.String())
+	}
+	if len(users) != 4 {
+		t.Fatalf("streamed %d users, want 4", len(users))
+	}
+
+	var empty bytes.Buffer
+	if err := NewUsersCache().StreamJSON(&empty); err != nil || empty.String() != "[]" {
+		t.Fatalf("empty stream = %q, %v", empty.String(), err)
+	}
+}
+
+func TestSaveAndLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	usersCache := newLoadedCache(t)
+	if err := usersCache.SaveToFile(path); err != nil {
+		t.Fatalf("SaveToFile: %v", err)
+	}
+	usersCache.RemoveUserData("uid_004")
+	if err := usersCache.SaveToFile(path); err != nil {
+		t.Fatalf("SaveToFile over existing file: %v", err)
+	}
+
+	loaded, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadFromFile: %v", err)
+	}
+	want, _ := usersCache.ExportJSON()
+	got, _ := loaded.ExportJSON()
+	if !bytes.Equal(got, want) {
+		t.Fatalf("loaded = %s, want %s", got, want)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Fatalf("directory has %d entries, want only the saved file", len(entries))
+	}
+}
+
+func TestLoadFromFileMissing(t *testing.T) {
+	_, err := LoadFromFile(filepath.Join(t.TempDir(), "missing.json"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("err = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestLoadJSONMap(t *testing.T) {
+	usersCache := NewUsersCache()
+	err := usersCache.LoadJSONMap([]byte(`{
+		"uid_001": {"uid":"uid_001","display_name":"king","game_level":1,"experience":100},
+		"uid_002": {"uid":"uid_002","display_name":"queen","game_level":1,"experience":110}
+	}`))
+	if err != nil {
+		t.Fatalf("LoadJSONMap: %v", err)
+	}
+	if got, want := sortedIds(usersCache.GetSnapshot()), []string{"uid_001", "uid_002"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ids = %v, want %v", got, want)
+	}
+	if users, found := usersCache.GetByDisplayName("queen"); !found || users[0].GetExperience() != 110 {
+		t.Fatal("queen not loaded with experience 110")
+	}
+}
+
+func TestLoadJSONMapMismatch(t *testing.T) {
+	usersCache := NewUsersCache()
+	err := usersCache.LoadJSONMap([]byte(`{
+		"uid_001": {"uid":"uid_001","display_name":"king","game_level":1,"experience":100},
+		"uid_002": {"uid":"uid_003","display_name":"soldier","game_level":1,"experience":120}
+	}`))
+	if err == nil || !strings.Contains(err.Error(), `"uid_002"`) {
+		t.Fatalf("LoadJSONMap error = %v, want one naming key uid_002", err)
+	}
+	if got := usersCache.Len(); got != 0 {
+		t.Fatalf("Len after mismatch = %d, want 0", got)
+	}
+}
+
+func TestImportJSONKeepsUpdatedAt(t *testing.T) {
+	source := newLoadedCache(t)
+	data, err := source.ExportJSON()
+	if err != nil {
+		t.Fatalf("ExportJSON: %v", err)
+	}
+	usersCache := NewUsersCache()
+	if err := usersCache.ImportJSON(data); err != nil {
+		t.Fatalf("ImportJSON: %v", err)
+	}
+	for _, userId := range []string{"uid_001", "uid_004"} {
+		want, _ := source.GetUserData(userId)
+		got, _ := usersCache.GetUserData(userId)
+		if !got.GetUpdatedAt().Equal(want.GetUpdatedAt()) {
+			t.Fatalf("%s UpdatedAt = %v, want %v", userId, got.GetUpdatedAt(), want.GetUpdatedAt())
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// GetField reads a field by its JSON name, false for unknown names
+func (u *UserData) GetField(jsonName string) (any, bool) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	u.touch()
+	switch jsonName {
+	case "uid":
+		return u.UserId, true
+	case "display_name":
+		return u.DisplayName, true
+	case "game_level":
+		return u.GameLevel, true
+	case "experience":
+		return u.Experience, true
+	}
+	return nil, false
+}
+
+// SetField writes a field by its JSON name. uid is read-only and value must have the
+// field's Go type. Setting experience also recomputes the game level like SetExperience
+func (u *UserData) SetField(jsonName string, value any) error {
+	u.lock()
+	u.touch()
+	oldLevel := u.GameLevel
+	err := u.setFieldLocked(jsonName, value)
+	if err == nil {
+		u.modified()
+	}
+	change := u.levelChangeLocked(oldLevel)
+	u.mu.Unlock()
+	change.notify()
+	return err
+}
+
+func (u *UserData) setFieldLocked(jsonName string, value any) error {
+	switch jsonName {
+	case "uid":
+		return errors.New("field uid is read-only")
+	case "display_name":
+		displayName, ok := value.(string)
+		if !ok {
+			return fieldTypeError(jsonName, value, u.DisplayName)
+		}
+		u.DisplayName = displayName
+	case "game_level":
+		gameLevel, ok := value.(int)
+		if !ok {
+			return fieldTypeError(jsonName, value, u.GameLevel)
+		}
+		u.GameLevel = gameLevel
+	case "experience":
+		experience, ok := value.(int64)
+		if !ok {
+			return fieldTypeError(jsonName, value, u.Experience)
+		}
+		u.Experience = experience
+		u.GameLevel = LevelCurve(u.Experience)
+	default:
+		return fmt.Errorf("unknown field %q", jsonName)
+	}
+	return nil
+}
+
+func fieldTypeError(jsonName string, value any, want any) error {
+	return fmt.Errorf("field %s: got %T, want %T", jsonName, value, want)
+}
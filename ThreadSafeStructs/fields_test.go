@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestGetSetField(t *testing.T) {
+	tests := []struct {
+		jsonName string
+		value    any
+	}{
+		{"display_name", "queen"},
+		{"game_level", 7},
+		{"experience", int64(250)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.jsonName, func(t *testing.T) {
+			userData := NewUserData("uid_001", "king", 1, 100)
+			if err := userData.SetField(tt.jsonName, tt.value); err != nil {
+				t.Fatalf("SetField: %v", err)
+			}
+			if got, ok := userData.GetField(tt.jsonName); !ok || got != tt.value {
+				t.Fatalf("GetField = (%v, %v), want (%v, true)", got, ok, tt.value)
+			}
+		})
+	}
+
+	userData := NewUserData("uid_001", "king", 1, 100)
+	if got, ok := userData.GetField("uid"); !ok || got != "uid_001" {
+		t.Fatalf("GetField(uid) = (%v, %v), want (uid_001, true)", got, ok)
+	}
+	if err := userData.SetField("experience", int64(250)); err != nil || userData.GetGameLevel() != LevelCurve(250) {
+		t.Fatalf("SetField(experience) err = %v, level = %d, want level %d", err, userData.GetGameLevel(), LevelCurve(250))
+	}
+}
+
+func TestSetFieldErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		jsonName string
+		value    any
+	}{
+		{"unknown", "password", "x"},
+		{"read-only uid", "uid", "uid_002"},
+		{"type mismatch", "experience", 250},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userData := NewUserData("uid_001", "king", 1, 100)
+			if err := userData.SetField(tt.jsonName, tt.value); err == nil {
+				t.Fatal("SetField returned nil error")
+			}
+			if got := toApiFields(userData); got != `{"uid":"uid_001","display_name":"king","game_level":1,"experience":100}` {
+				t.Fatalf("user changed on error: %s", got)
+			}
+		})
+	}
+	if _, ok := NewUserData("uid_001", "king", 1, 100).GetField("password"); ok {
+		t.Fatal("GetField on unknown name returned true")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultGiftFee charges nothing
+func DefaultGiftFee(amount int64) int64 {
+	return 0
+}
+
+// GiftFee returns the part of a gifted amount withheld from the recipient. Results
+// outside [0, amount] are clamped
+var GiftFee func(amount int64) int64 = DefaultGiftFee
+
+// Gift moves amount experience from one user to another minus GiftFee, so the sender
+// pays amount and the recipient gets the rest, clamped at math.MaxInt64 like
+// AddExperience. Both levels are recomputed and OnLevelChange and the recipient's
+// OnLevelUp fire after unlocking. It uses LockUsers, so concurrent gifts in opposite
+// directions can't deadlock
+func (uc *UsersCache) Gift(fromId string, toId string, amount int64) error {
+	if amount <= 0 {
+		return fmt.Errorf("gift amount must be positive, got %d", amount)
+	}
+	if fromId == toId {
+		return errors.New("cannot gift to self")
+	}
+	fee := GiftFee(amount)
+	if fee < 0 {
+		fee = 0
+	}
+	if fee > amount {
+		fee = amount
+	}
+
+	unlock, users := uc.LockUsers(fromId, toId)
+	var from, to *UserData
+	for _, userData := range users {
+		switch userData.UserId {
+		case fromId:
+			from = userData
+		case toId:
+			to = userData
+		}
+	}
+	if from == nil || to == nil {
+		unlock()
+		missing := fromId
+		if from != nil {
+			missing = toId
+		}
+		return fmt.Errorf("%w: %q", ErrUserNotFound, missing)
+	}
+	if from.Experience < amount {
+		unlock()
+		return fmt.Errorf("%w: user %q has %d, cannot gift %d", ErrInsufficientExperience, fromId, from.Experience, amount)
+	}
+	fromLevel := from.GameLevel
+	from.Experience -= amount
+	from.GameLevel = LevelCurve(from.Experience)
+	from.modified()
+	fromChange := from.levelChangeLocked(fromLevel)
+	var toLevelUp, toChange levelChange
+	if received := amount - fee; received > 0 {
+		_, toLevelUp, toChange = to.addExperienceLocked(received)
+	}
+	unlock()
+
+	uc.lru.touch(fromId)
+	uc.lru.touch(toId)
+	uc.reindexLevels(fromId, toId)
+	fromChange.notify()
+	toChange.notify()
+	toLevelUp.notify()
+	return nil
+}
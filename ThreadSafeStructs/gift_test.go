@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestGift(t *testing.T) {
+	defer func(fee func(int64) int64) { GiftFee = fee }(GiftFee)
+	GiftFee = func(amount int64) int64 { return amount / 10 }
+
+	usersCache := newLoadedCache(t)
+	if err := usersCache.Gift("uid_003", "uid_001", 100); err != nil {
+		t.Fatalf("Gift: %v", err)
+	}
+	from, _ := usersCache.GetUserData("uid_003")
+	to, _ := usersCache.GetUserData("uid_001")
+	if from.GetExperience() != 20 || to.GetExperience() != 190 {
+		t.Fatalf("experience after gift = %d and %d, want 20 and 190", from.GetExperience(), to.GetExperience())
+	}
+	if from.GetGameLevel() != LevelCurve(20) || to.GetGameLevel() != LevelCurve(190) {
+		t.Fatalf("levels after gift = %d and %d, not recomputed", from.GetGameLevel(), to.GetGameLevel())
+	}
+}
+
+func TestGiftErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		fromId string
+		toId   string
+		amount int64
+		want   error
+	}{
+		{"insufficient", "uid_001", "uid_002", 101, ErrInsufficientExperience},
+		{"missing sender", "uid_404", "uid_002", 10, ErrUserNotFound},
+		{"missing recipient", "uid_001", "uid_404", 10, ErrUserNotFound},
+		{"non-positive", "uid_001", "uid_002", 0, nil},
+		{"self", "uid_001", "uid_001", 10, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usersCache := newLoadedCache(t)
+			err := usersCache.Gift(tt.fromId, tt.toId, tt.amount)
+			if err == nil || (tt.want != nil && !errors.Is(err, tt.want)) {
+				t.Fatalf("Gift error = %v, want %v", err, tt.want)
+			}
+			from, _ := usersCache.GetUserData("uid_001")
+			if got := from.GetExperience(); got != 100 {
+				t.Fatalf("uid_001 experience = %d, want 100 unchanged", got)
+			}
+		})
+	}
+}
+
+func TestGiftClampsAndNotifies(t *testing.T) {
+	usersCache := NewUsersCache()
+	usersCache.AddUserData(
+		NewUserData("uid_a", "a", LevelCurve(250), 250),
+		NewUserData("uid_b", "b", LevelCurve(50), 50),
+		NewUserData("uid_c", "c", LevelCurve(math.MaxInt64-10), math.MaxInt64-10),
+	)
+	from, _ := usersCache.GetUserData("uid_a")
+	to, _ := usersCache.GetUserData("uid_b")
+
+	var changes []string
+	record := func(u *UserData, oldLevel, newLevel int) {
+		changes = append(changes, fmt.Sprintf("%s:%d->%d", u.GetUserId(), oldLevel, newLevel))
+	}
+	from.SetOnLevelChange(record)
+	to.SetOnLevelChange(record)
+	levelUps := 0
+	to.SetOnLevelUp(func(*UserData, int, int) { levelUps++ })
+
+	if err := usersCache.Gift("uid_a", "uid_b", 200); err != nil {
+		t.Fatalf("Gift: %v", err)
+	}
+	want := []string{
+		fmt.Sprintf("uid_a:%d->%d", LevelCurve(250), LevelCurve(50)),
+		fmt.Sprintf("uid_b:%d->%d", LevelCurve(50), LevelCurve(250)),
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("changes = %v, want %v", changes, want)
+	}
+	if levelUps != 1 {
+		t.Fatalf("level-up callback fired %d times, want 1", levelUps)
+	}
+
+	if err := usersCache.Gift("uid_b", "uid_c", 100); err != nil {
+		t.Fatalf("Gift: %v", err)
+	}
+	recipient, _ := usersCache.GetUserData("uid_c")
+	if got := recipient.GetExperience(); got != math.MaxInt64 {
+		t.Fatalf("recipient experience = %d, want math.MaxInt64", got)
+	}
+}
@@ -1,6 +1,6 @@
 module threadsafestructs
 
-go 1.18
+go 1.23
 
 require github.com/jackc/pgtype v1.14.0
 
@@ -0,0 +1,50 @@
+package main
+
+// experienceRing keeps the last len(values) experience values, zero size disables it
+type experienceRing struct {
+	values []int64
+	next   int
+	count  int
+}
+
+func (r *experienceRing) push(value int64) {
+	if len(r.values) == 0 {
+		return
+	}
+	r.values[r.next] = value
+	r.next = (r.next + 1) % len(r.values)
+	if r.count < len(r.values) {
+		r.count++
+	}
+}
+
+// snapshot returns the recorded values oldest first
+func (r *experienceRing) snapshot() []int64 {
+	res := make([]int64, 0, r.count)
+	start := r.next - r.count
+	if start < 0 {
+		start += len(r.values)
+	}
+	for i := 0; i < r.count; i++ {
+		res = append(res, r.values[(start+i)%len(r.values)])
+	}
+	return res
+}
+
+// SetExperienceHistorySize makes AddExperience record the last size experience values,
+// 0 disables recording. Resizing discards the recorded history
+func (u *UserData) SetExperienceHistorySize(size int) {
+	u.lock()
+	defer u.mu.Unlock()
+	if size < 0 {
+		size = 0
+	}
+	u.history = experienceRing{values: make([]int64, size)}
+}
+
+// ExperienceHistory returns a copy of the recorded experience values, oldest first
+func (u *UserData) ExperienceHistory() []int64 {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.history.snapshot()
+}
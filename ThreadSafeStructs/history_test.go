@@ -0,0 +1,23 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExperienceHistory(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 0, 0)
+	userData.AddExperience(1)
+	if got := userData.ExperienceHistory(); len(got) != 0 {
+		t.Fatalf("history with recording disabled = %v, want empty", got)
+	}
+
+	userData.SetExperienceHistorySize(3)
+	for i := 0; i < 5; i++ {
+		userData.AddExperience(10)
+	}
+	want := []int64{31, 41, 51}
+	if got := userData.ExperienceHistory(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("history = %v, want %v", got, want)
+	}
+}
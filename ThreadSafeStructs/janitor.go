@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// janitor runs EvictIdle in the background, see StartJanitor
+type janitor struct {
+	mu      sync.Mutex
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// EvictIdle removes users whose LastAccess is older than maxIdle and returns how many were removed
+func (uc *UsersCache) EvictIdle(maxIdle time.Duration) int {
+	cutoff := time.Now().Add(-maxIdle)
+	uc.mu.Lock()
+	defer uc.unlock()
+	evicted := 0
+	for userId, userData := range uc.userDataById {
+		if userData.LastAccess().Before(cutoff) {
+			uc.deleteLocked(userId)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// StartJanitor launches a goroutine calling EvictIdle(maxIdle) every interval until
+// StopJanitor is called. Starting again replaces the running janitor
+func (uc *UsersCache) StartJanitor(interval time.Duration, maxIdle time.Duration) {
+	uc.janitor.mu.Lock()
+	defer uc.janitor.mu.Unlock()
+	uc.stopJanitorLocked()
+
+	done, stopped := make(chan struct{}), make(chan struct{})
+	uc.janitor.done, uc.janitor.stopped = done, stopped
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				uc.EvictIdle(maxIdle)
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// StopJanitor stops the janitor and waits for its goroutine to exit.
+// It is a no-op if the janitor is not running
+func (uc *UsersCache) StopJanitor() {
+	uc.janitor.mu.Lock()
+	defer uc.janitor.mu.Unlock()
+	uc.stopJanitorLocked()
+}
+
+func (uc *UsersCache) stopJanitorLocked() {
+	if uc.janitor.done == nil {
+		return
+	}
+	close(uc.janitor.done)
+	<-uc.janitor.stopped
+	uc.janitor.done, uc.janitor.stopped = nil, nil
+}
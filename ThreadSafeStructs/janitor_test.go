@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvictIdle(t *testing.T) {
+	usersCache := NewUsersCache()
+	idle := NewUserData("uid_001", "king", 1, 100)
+	fresh := NewUserData("uid_002", "queen", 1, 110)
+	usersCache.AddUserData(idle, fresh)
+	idle.touchAt(time.Now().Add(-time.Hour))
+
+	if evicted := usersCache.EvictIdle(time.Minute); evicted != 1 {
+		t.Fatalf("evicted = %d, want 1", evicted)
+	}
+	if _, found := usersCache.GetUserData("uid_001"); found {
+		t.Fatal("idle user survived eviction")
+	}
+	if _, found := usersCache.GetUserData("uid_002"); !found {
+		t.Fatal("fresh user was evicted")
+	}
+}
+
+func TestLastAccessUpdatedOnAccess(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 1, 100)
+	past := time.Now().Add(-time.Hour)
+	userData.touchAt(past)
+	userData.GetExperience()
+	if !userData.LastAccess().After(past) {
+		t.Fatal("GetExperience did not update LastAccess")
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	usersCache := NewUsersCache()
+	usersCache.StopJanitor() // must be safe before Start
+
+	idle := NewUserData("uid_001", "king", 1, 100)
+	usersCache.AddUserData(idle)
+	idle.touchAt(time.Now().Add(-time.Hour))
+
+	usersCache.StartJanitor(time.Millisecond, time.Minute)
+	deadline := time.Now().Add(time.Second)
+	for usersCache.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("janitor did not evict the idle user")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	usersCache.StopJanitor()
+	usersCache.StopJanitor()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// Leaderboard keeps the n users with the most experience without sorting the whole
+// cache, see LeaderboardTopN. It reflects experience changed through cache methods
+// that keep the level index in sync, such as CacheAddExperience, UpdateUserData and
+// Transfer. Changes made through UserData methods on a cached user are not seen
+type Leaderboard struct {
+	uc *UsersCache
+	n  int
+
+	mu sync.Mutex
+	// min-heap of the current top n, the weakest entry first
+	entries []leaderboardEntry
+	pos     map[string]int
+	// set when a member dropped out or lost experience, an outsider may now belong
+	// in the top n so the heap is rebuilt on the next Top
+	dirty bool
+}
+
+type leaderboardEntry struct {
+	userId     string
+	userData   *UserData
+	experience int64
+}
+
+// LeaderboardTopN attaches a leaderboard of the n users with the most experience to
+// the cache, replacing any previous one, which stops being updated
+func (uc *UsersCache) LeaderboardTopN(n int) *Leaderboard {
+	uc.mu.Lock()
+	defer uc.unlock()
+	leaderboard := &Leaderboard{uc: uc, n: n}
+	leaderboard.rebuildLocked()
+	uc.leaderboard = leaderboard
+	return leaderboard
+}
+
+// Top returns copies of the leaderboard users in TopByExperience order
+func (l *Leaderboard) Top() []UserData {
+	l.uc.mu.RLock()
+	defer l.uc.mu.RUnlock()
+	l.mu.Lock()
+	if l.dirty {
+		l.rebuildLocked()
+	}
+	res := make([]UserData, 0, len(l.entries))
+	for _, entry := range l.entries {
+		res = append(res, entry.userData.CopyValue())
+	}
+	l.mu.Unlock()
+	sortByExperience(res)
+	return res
+}
+
+// rebuildLocked refills the heap from every cached user, the caller holds the
+// cache lock and, once the leaderboard is attached, l.mu
+func (l *Leaderboard) rebuildLocked() {
+	l.entries = l.entries[:0]
+	l.pos = make(map[string]int, l.n)
+	l.dirty = false
+	if l.n <= 0 {
+		return
+	}
+	for userId, userData := range l.uc.userDataById {
+		l.offerLocked(userId, userData, userData.experience())
+	}
+}
+
+// update records userData's current experience, the caller holds the cache lock
+func (l *Leaderboard) update(userId string, userData *UserData) {
+	if l == nil || l.n <= 0 {
+		return
+	}
+	experience := userData.experience()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i, found := l.pos[userId]
+	if !found {
+		l.offerLocked(userId, userData, experience)
+		return
+	}
+	if experience < l.entries[i].experience {
+		l.dirty = true
+	}
+	l.entries[i].userData = userData
+	l.entries[i].experience = experience
+	heap.Fix((*leaderboardHeap)(l), i)
+}
+
+// remove drops userId from the top n, the caller holds the cache lock
+func (l *Leaderboard) remove(userId string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if i, found := l.pos[userId]; found {
+		heap.Remove((*leaderboardHeap)(l), i)
+		l.dirty = true
+	}
+}
+
+// reset empties the leaderboard along with the cache, the caller holds the cache lock
+func (l *Leaderboard) reset() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = l.entries[:0]
+	l.pos = make(map[string]int, l.n)
+	l.dirty = false
+}
+
+// offerLocked adds a user that is not in the heap if it beats the weakest member
+func (l *Leaderboard) offerLocked(userId string, userData *UserData, experience int64) {
+	entry := leaderboardEntry{userId: userId, userData: userData, experience: experience}
+	h := (*leaderboardHeap)(l)
+	if len(l.entries) < l.n {
+		heap.Push(h, entry)
+		return
+	}
+	if weaker(l.entries[0], entry) {
+		delete(l.pos, l.entries[0].userId)
+		l.entries[0] = entry
+		l.pos[userId] = 0
+		heap.Fix(h, 0)
+	}
+}
+
+// weaker orders entries like TopByExperience reversed: less experience, then larger UserId
+func weaker(a, b leaderboardEntry) bool {
+	if a.experience != b.experience {
+		return a.experience < b.experience
+	}
+	return a.userId > b.userId
+}
+
+// experience reads Experience without counting as an access
+func (u *UserData) experience() int64 {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.Experience
+}
+
+// leaderboardHeap implements heap.Interface over a Leaderboard's entries
+type leaderboardHeap Leaderboard
+
+func (h *leaderboardHeap) Len() int           { return len(h.entries) }
+func (h *leaderboardHeap) Less(i, j int) bool { return weaker(h.entries[i], h.entries[j]) }
+
+func (h *leaderboardHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.pos[h.entries[i].userId] = i
+	h.pos[h.entries[j].userId] = j
+}
+
+func (h *leaderboardHeap) Push(x any) {
+	entry := x.(leaderboardEntry)
+	h.pos[entry.userId] = len(h.entries)
+	h.entries = append(h.entries, entry)
+}
+
+func (h *leaderboardHeap) Pop() any {
+	last := len(h.entries) - 1
+	entry := h.entries[last]
+	h.entries = h.entries[:last]
+	delete(h.pos, entry.userId)
+	return entry
+}
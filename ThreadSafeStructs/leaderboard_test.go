@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLeaderboardTopN(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	leaderboard := usersCache.LeaderboardTopN(2)
+	if got, want := userIds(leaderboard.Top()), []string{"uid_003", "uid_004"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("initial top = %v, want %v", got, want)
+	}
+
+	steps := []struct {
+		name   string
+		update func()
+		want   []string
+	}{
+		{"outsider climbs", func() { _, _ = usersCache.CacheAddExperience("uid_001", 50) }, []string{"uid_001", "uid_003"}},
+		{"new user enters", func() { usersCache.AddUserData(NewUserData("uid_005", "knight", 0, 500)) }, []string{"uid_005", "uid_001"}},
+		{"member drops", func() { _, _ = usersCache.CacheAddExperience("uid_005", -450) }, []string{"uid_001", "uid_003"}},
+		{"member removed", func() { usersCache.RemoveUserData("uid_001") }, []string{"uid_003", "uid_004"}},
+		{"transfer", func() { _ = usersCache.Transfer("uid_003", "uid_002", 20) }, []string{"uid_002", "uid_004"}},
+	}
+	for _, step := range steps {
+		step.update()
+		if got := userIds(leaderboard.Top()); !reflect.DeepEqual(got, step.want) {
+			t.Fatalf("%s: top = %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	usersCache.Clear()
+	if got := leaderboard.Top(); len(got) != 0 {
+		t.Fatalf("top after Clear = %v, want empty", userIds(got))
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+func (uc *UsersCache) indexLevelLocked(userId string, level int) {
+	ids, found := uc.userIdsByLevel[level]
+	if !found {
+		ids = make(map[string]struct{})
+		uc.userIdsByLevel[level] = ids
+	}
+	ids[userId] = struct{}{}
+	uc.levelById[userId] = level
+}
+
+func (uc *UsersCache) unindexLevelLocked(userId string) {
+	level, found := uc.levelById[userId]
+	if !found {
+		return
+	}
+	delete(uc.levelById, userId)
+	ids := uc.userIdsByLevel[level]
+	delete(ids, userId)
+	if len(ids) == 0 {
+		delete(uc.userIdsByLevel, level)
+	}
+}
+
+// reindexLevels moves the given users to the level bucket matching their current
+// GameLevel and refreshes their TotalExperience share and leaderboard entry. It is
+// called after the mutation with the lock released in between, so it reads the latest
+// level and the last caller always leaves the index up to date. The write lock is only
+// taken when a user's level or experience no longer matches what is indexed
+func (uc *UsersCache) reindexLevels(userIds ...string) {
+	if len(userIds) == 0 || !uc.levelsStale(userIds) {
+		return
+	}
+	uc.mu.Lock()
+	defer uc.unlock()
+	for _, userId := range userIds {
+		userData, found := uc.userDataById[userId]
+		if !found {
+			continue
+		}
+		level := userData.GetGameLevel()
+		if indexed, found := uc.levelById[userId]; !found || indexed != level {
+			uc.unindexLevelLocked(userId)
+			uc.indexLevelLocked(userId, level)
+		}
+		uc.trackExperienceLocked(userId, userData.experience())
+		uc.leaderboard.update(userId, userData)
+	}
+}
+
+// levelsStale reports under the read lock whether any of the given users has a level
+// or experience that differs from the indexed one. The leaderboard is refreshed
+// together with the experience, so it cannot be stale when the experience is not
+func (uc *UsersCache) levelsStale(userIds []string) bool {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+	for _, userId := range userIds {
+		userData, found := uc.userDataById[userId]
+		if !found {
+			continue
+		}
+		userData.mu.RLock()
+		level, experience := userData.GameLevel, userData.Experience
+		userData.mu.RUnlock()
+		if indexed, found := uc.levelById[userId]; !found || indexed != level {
+			return true
+		}
+		if tracked, found := uc.experienceById[userId]; !found || tracked != experience {
+			return true
+		}
+	}
+	return false
+}
+
+// CacheAddExperience is AddExperience on a cached user that also keeps the level
+// index used by UsersAtLevel in sync. Level changes made through UserData methods
+// directly, or inside WithUser, bypass the index
+func (uc *UsersCache) CacheAddExperience(userId string, delta int64) (level int, err error) {
+	userData, found := uc.GetUserData(userId)
+	if !found {
+		return 0, fmt.Errorf("%w: %q", ErrUserNotFound, userId)
+	}
+	level, _ = userData.AddExperience(delta)
+	uc.reindexLevels(userId)
+	return level, nil
+}
+
+// UsersAtLevel returns copies of the users indexed at level, sorted by UserId
+func (uc *UsersCache) UsersAtLevel(level int) []UserData {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+	ids := uc.userIdsByLevel[level]
+	res := make([]UserData, 0, len(ids))
+	for userId := range ids {
+		res = append(res, uc.userDataById[userId].CopyValue())
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].UserId < res[j].UserId })
+	return res
+}
+
+// recomputeLevel re-derives GameLevel from Experience with the current LevelCurve
+// and returns the pending OnLevelChange notification, for delivery after unlocking
+func (u *UserData) recomputeLevel() levelChange {
+	u.lock()
+	defer u.mu.Unlock()
+	oldLevel := u.GameLevel
+	u.GameLevel = LevelCurve(u.Experience)
+	if u.GameLevel != oldLevel {
+		u.modified()
+	}
+	return u.levelChangeLocked(oldLevel)
+}
+
+// RecomputeAllLevels re-derives every user's GameLevel from Experience, for use after
+// LevelCurve was swapped. It returns how many levels changed
+func (uc *UsersCache) RecomputeAllLevels() (changed int) {
+	var (
+		userIds []string
+		changes []levelChange
+	)
+	uc.mu.RLock()
+	for userId, userData := range uc.userDataById {
+		if change := userData.recomputeLevel(); change.newLevel != change.oldLevel {
+			userIds = append(userIds, userId)
+			changes = append(changes, change)
+		}
+	}
+	uc.mu.RUnlock()
+	uc.reindexLevels(userIds...)
+	notifyAll(changes)
+	return len(userIds)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUsersAtLevel(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	usersCache.AddUserData(NewUserData("uid_005", "knight", 0, 50))
+	if got, want := userIds(usersCache.UsersAtLevel(1)), []string{"uid_001", "uid_002", "uid_003", "uid_004"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("level 1 = %v, want %v", got, want)
+	}
+
+	level, err := usersCache.CacheAddExperience("uid_002", 100)
+	if err != nil || level != 2 {
+		t.Fatalf("CacheAddExperience = %d, %v, want 2, nil", level, err)
+	}
+	if got, want := userIds(usersCache.UsersAtLevel(1)), []string{"uid_001", "uid_003", "uid_004"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("level 1 after move = %v, want %v", got, want)
+	}
+	if got, want := userIds(usersCache.UsersAtLevel(2)), []string{"uid_002"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("level 2 after move = %v, want %v", got, want)
+	}
+
+	if err := usersCache.Transfer("uid_003", "uid_005", 70); err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+	if got, want := userIds(usersCache.UsersAtLevel(0)), []string{"uid_003"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("level 0 after transfer = %v, want %v", got, want)
+	}
+
+	usersCache.RemoveUserData("uid_002")
+	if got := usersCache.UsersAtLevel(2); len(got) != 0 {
+		t.Fatalf("level 2 after remove = %v, want empty", userIds(got))
+	}
+	if _, err := usersCache.CacheAddExperience("uid_404", 1); err == nil {
+		t.Fatal("CacheAddExperience on missing user returned nil error")
+	}
+}
+
+func TestRecomputeAllLevels(t *testing.T) {
+	defer func(curve func(int64) int) { LevelCurve = curve }(LevelCurve)
+	usersCache := newLoadedCache(t)
+
+	LevelCurve = func(experience int64) int {
+		if experience > 110 {
+			return 2
+		}
+		return 1
+	}
+	if changed := usersCache.RecomputeAllLevels(); changed != 2 {
+		t.Fatalf("changed = %d, want 2", changed)
+	}
+	for userId, want := range map[string]int{"uid_001": 1, "uid_002": 1, "uid_003": 2, "uid_004": 2} {
+		userData, _ := usersCache.GetUserData(userId)
+		if got := userData.GetGameLevel(); got != want {
+			t.Fatalf("%s level = %d, want %d", userId, got, want)
+		}
+	}
+	if got, want := userIds(usersCache.UsersAtLevel(2)), []string{"uid_003", "uid_004"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("level 2 = %v, want %v", got, want)
+	}
+	if changed := usersCache.RecomputeAllLevels(); changed != 0 {
+		t.Fatalf("second pass changed = %d, want 0", changed)
+	}
+}
+
+// watchLevelCallbacks registers level callbacks on every cached user that fail the
+// test when they run while the cache lock is held, and returns how many ran
+func watchLevelCallbacks(t *testing.T, uc *UsersCache) *int {
+	t.Helper()
+	calls := 0
+	callback := func(*UserData, int, int) {
+		calls++
+		if !uc.mu.TryLock() {
+			t.Error("level callback ran under the cache lock")
+			return
+		}
+		uc.mu.Unlock()
+	}
+	uc.PerformReadOperation(func(userData *UserData) {
+		userData.SetOnLevelUp(callback)
+		userData.SetOnLevelChange(callback)
+	})
+	return &calls
+}
+
+func TestRecomputeAllLevelsNotifiesAfterUnlock(t *testing.T) {
+	defer func(curve func(int64) int) { LevelCurve = curve }(LevelCurve)
+	usersCache := newLoadedCache(t)
+	calls := watchLevelCallbacks(t, usersCache)
+
+	LevelCurve = func(experience int64) int { return 7 }
+	if changed := usersCache.RecomputeAllLevels(); changed != 4 {
+		t.Fatalf("changed = %d, want 4", changed)
+	}
+	if *calls != 4 {
+		t.Fatalf("level callbacks ran %d times, want 4", *calls)
+	}
+}
+
+func TestReindexLevelsSkipsWriteLockWhenUnchanged(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	userData, _ := usersCache.GetUserData("uid_002")
+	if _, err := usersCache.CacheAddExperience("uid_002", 100); err != nil {
+		t.Fatalf("CacheAddExperience: %v", err)
+	}
+
+	usersCache.mu.RLock()
+	done := make(chan struct{})
+	go func() {
+		usersCache.reindexLevels("uid_002", "uid_404")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		usersCache.mu.RUnlock()
+		t.Fatal("reindexLevels of an up-to-date user waited for the write lock")
+	}
+	usersCache.mu.RUnlock()
+
+	userData.AddExperience(100)
+	usersCache.reindexLevels("uid_002")
+	if got := userIds(usersCache.UsersAtLevel(userData.GetGameLevel())); !reflect.DeepEqual(got, []string{"uid_002"}) {
+		t.Fatalf("level %d = %v, want [uid_002]", userData.GetGameLevel(), got)
+	}
+	if got, want := usersCache.TotalExperience(), int64(450+200); got != want {
+		t.Fatalf("total experience = %d, want %d", got, want)
+	}
+}
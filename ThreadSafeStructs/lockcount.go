@@ -0,0 +1,22 @@
+//go:build lockcount
+
+package main
+
+import "sync/atomic"
+
+// lockCounter counts write lock acquisitions in lockcount builds
+type lockCounter struct {
+	n atomic.Uint64
+}
+
+// lock takes the write lock and counts it
+func (u *UserData) lock() {
+	u.mu.Lock()
+	u.locks.n.Add(1)
+}
+
+// LockCount returns how many times the user's write lock was taken. It is only
+// counted in builds with the lockcount tag and is always 0 otherwise
+func (u *UserData) LockCount() uint64 {
+	return u.locks.n.Load()
+}
@@ -0,0 +1,26 @@
+//go:build lockcount
+
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestLockCount(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 0, 0)
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			userData.AddExperience(1)
+		}()
+	}
+	wg.Wait()
+	userData.GetExperience()
+	if got := userData.LockCount(); got != n {
+		t.Fatalf("LockCount = %d, want %d", got, n)
+	}
+}
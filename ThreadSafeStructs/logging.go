@@ -0,0 +1,26 @@
+package main
+
+// Logger receives a line per cache mutation, it is called after the cache lock is released
+type Logger interface {
+	Logf(format string, args ...any)
+}
+
+// SetLogger logs UpdateUserData and every add or remove, from AddUserData,
+// AddUserDataUnique, TryAddUserData, ApplyIfNewer, GetOrCreate, MergeFrom, SetUserId,
+// the JSON imports and RemoveUserData, to logger. nil disables logging
+func (uc *UsersCache) SetLogger(logger Logger) {
+	uc.mu.Lock()
+	defer uc.unlock()
+	uc.logger = logger
+}
+
+// logMutations reports op for each id, logger is captured under the cache lock
+// and called after it is released
+func logMutations(logger Logger, op string, userIds ...string) {
+	if logger == nil {
+		return
+	}
+	for _, userId := range userIds {
+		logger.Logf("users cache: %s %q", op, userId)
+	}
+}
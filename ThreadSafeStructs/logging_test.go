@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type capturingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *capturingLogger) Logf(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestLogger(t *testing.T) {
+	logger := &capturingLogger{}
+	usersCache := NewUsersCacheWithOptions(WithLogger(logger))
+
+	usersCache.AddUserData(NewUserData("uid_001", "king", 1, 100))
+	usersCache.UpdateUserData("uid_001", func(u *UserData) { u.Experience = 150 })
+	usersCache.RemoveUserData("uid_001")
+	usersCache.RemoveUserData("uid_404")
+
+	want := []string{
+		`users cache: add "uid_001"`,
+		`users cache: update "uid_001"`,
+		`users cache: remove "uid_001"`,
+	}
+	if !reflect.DeepEqual(logger.lines, want) {
+		t.Fatalf("log lines = %q, want %q", logger.lines, want)
+	}
+
+	usersCache.SetLogger(nil)
+	usersCache.AddUserData(NewUserData("uid_002", "queen", 1, 110))
+	if len(logger.lines) != len(want) {
+		t.Fatalf("logged %d lines after SetLogger(nil), want %d", len(logger.lines), len(want))
+	}
+}
+
+func TestLoggerSeesEveryInsertPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(uc *UsersCache)
+		want   []string
+	}{
+		{
+			name: "AddUserDataUnique",
+			mutate: func(uc *UsersCache) {
+				uc.AddUserDataUnique(NewUserData("uid_002", "queen", 1, 110))
+			},
+			want: []string{`users cache: add "uid_002"`},
+		},
+		{
+			name: "TryAddUserData",
+			mutate: func(uc *UsersCache) {
+				uc.TryAddUserData(NewUserData("uid_002", "queen", 1, 110))
+			},
+			want: []string{`users cache: add "uid_002"`},
+		},
+		{
+			name: "ApplyIfNewer",
+			mutate: func(uc *UsersCache) {
+				uc.ApplyIfNewer(NewUserData("uid_001", "emperor", 1, 100))
+			},
+			want: []string{`users cache: add "uid_001"`},
+		},
+		{
+			name: "GetOrCreate",
+			mutate: func(uc *UsersCache) {
+				uc.GetOrCreate("uid_001", func() *UserData { return NewUserData("uid_001", "other", 0, 0) })
+				uc.GetOrCreate("uid_002", func() *UserData { return NewUserData("uid_002", "queen", 1, 110) })
+			},
+			want: []string{`users cache: add "uid_002"`},
+		},
+		{
+			name: "MergeFrom",
+			mutate: func(uc *UsersCache) {
+				other := NewUsersCache()
+				other.AddUserData(NewUserData("uid_002", "queen", 1, 110))
+				uc.MergeFrom(other, nil)
+			},
+			want: []string{`users cache: add "uid_002"`},
+		},
+		{
+			name: "ImportJSONMerge",
+			mutate: func(uc *UsersCache) {
+				if err := uc.ImportJSONMerge([]byte(`[{"uid":"uid_001","display_name":"other","game_level":0,"experience":0},{"uid":"uid_002","display_name":"queen","game_level":1,"experience":110}]`)); err != nil {
+					t.Fatalf("ImportJSONMerge: %v", err)
+				}
+			},
+			want: []string{`users cache: add "uid_002"`},
+		},
+		{
+			name: "SetUserId",
+			mutate: func(uc *UsersCache) {
+				uc.SetUserId("uid_001", "uid_100")
+			},
+			want: []string{`users cache: remove "uid_001"`, `users cache: add "uid_100"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wal bytes.Buffer
+			usersCache := NewUsersCacheWithOptions(WithWAL(NewJSONLinesAppender(&wal)))
+			usersCache.AddUserData(NewUserData("uid_001", "king", 1, 100))
+			logger := &capturingLogger{}
+			usersCache.SetLogger(logger)
+			events, unsubscribe := usersCache.Subscribe()
+			defer unsubscribe()
+			wal.Reset()
+
+			tt.mutate(usersCache)
+
+			if !reflect.DeepEqual(logger.lines, tt.want) {
+				t.Fatalf("log lines = %q, want %q", logger.lines, tt.want)
+			}
+			if got := len(events); got != len(tt.want) {
+				t.Fatalf("published %d events, want %d", got, len(tt.want))
+			}
+			if got := bytes.Count(wal.Bytes(), []byte("\n")); got != len(tt.want) {
+				t.Fatalf("journaled %d entries, want %d", got, len(tt.want))
+			}
+		})
+	}
+}
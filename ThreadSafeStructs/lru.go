@@ -0,0 +1,92 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lruList orders cached ids by recency of use, front is the most recent. It has its
+// own lock because GetUserData marks recency under the cache read lock. A nil
+// *lruList disables tracking
+type lruList struct {
+	mu       sync.Mutex
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+func newLRUList() *lruList {
+	return &lruList{order: list.New(), elements: make(map[string]*list.Element)}
+}
+
+// touch marks a tracked id as the most recently used
+func (l *lruList) touch(userId string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if element, found := l.elements[userId]; found {
+		l.order.MoveToFront(element)
+	}
+}
+
+// add starts tracking userId as the most recently used
+func (l *lruList) add(userId string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if element, found := l.elements[userId]; found {
+		l.order.MoveToFront(element)
+		return
+	}
+	l.elements[userId] = l.order.PushFront(userId)
+}
+
+func (l *lruList) remove(userId string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if element, found := l.elements[userId]; found {
+		l.order.Remove(element)
+		delete(l.elements, userId)
+	}
+}
+
+func (l *lruList) reset() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.order.Init()
+	l.elements = make(map[string]*list.Element)
+}
+
+// oldest returns the least recently used id
+func (l *lruList) oldest() (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if back := l.order.Back(); back != nil {
+		return back.Value.(string), true
+	}
+	return "", false
+}
+
+// evictLRULocked removes least recently used users until the cache fits SetMaxUsers,
+// the caller holds uc.mu. Like EvictIdle, evictions are not journaled or published
+func (uc *UsersCache) evictLRULocked() {
+	if uc.lru == nil || uc.maxUsers <= 0 {
+		return
+	}
+	for len(uc.userDataById) > uc.maxUsers {
+		userId, found := uc.lru.oldest()
+		if !found {
+			return
+		}
+		uc.deleteLocked(userId)
+	}
+}
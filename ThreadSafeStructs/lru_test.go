@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestLRUEviction(t *testing.T) {
+	usersCache := NewUsersCacheWithOptions(WithLRU(3))
+	for _, userId := range []string{"uid_001", "uid_002", "uid_003"} {
+		usersCache.AddUserData(NewUserData(userId, "knight", 0, 0))
+	}
+	usersCache.GetUserData("uid_001")
+	usersCache.AddUserData(NewUserData("uid_004", "knight", 0, 0))
+
+	if got, want := sortedKeys(usersCache), []string{"uid_001", "uid_003", "uid_004"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("keys = %v, want %v with uid_002 evicted", got, want)
+	}
+
+	usersCache.UpdateUserData("uid_003", func(u *UserData) { u.Experience = 10 })
+	if err := usersCache.TryAddUserData(NewUserData("uid_005", "knight", 0, 0)); err != nil {
+		t.Fatalf("TryAddUserData with LRU: %v", err)
+	}
+	if got, want := sortedKeys(usersCache), []string{"uid_003", "uid_004", "uid_005"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("keys = %v, want %v with uid_001 evicted", got, want)
+	}
+
+	usersCache.SetMaxUsers(1)
+	if got, want := sortedKeys(usersCache), []string{"uid_005"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("keys after lowering the cap = %v, want %v", got, want)
+	}
+}
+
+func sortedKeys(uc *UsersCache) []string {
+	keys := uc.Keys()
+	sort.Strings(keys)
+	return keys
+}
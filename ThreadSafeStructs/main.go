@@ -1,23 +1,92 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"os"
 	"os/signal"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
+	"unicode/utf8"
+	"unsafe"
 )
 
+// ExperiencePerLevel is the amount of experience needed to gain one game level
+const ExperiencePerLevel = 100
+
+// DefaultLevelCurve is the linear leveling rule, one level per ExperiencePerLevel
+func DefaultLevelCurve(experience int64) int {
+	return int(experience / ExperiencePerLevel)
+}
+
+// LevelCurve derives the game level from experience, replace it at startup
+// before users are shared between goroutines
+var LevelCurve func(experience int64) int = DefaultLevelCurve
+
+// MaxDisplayNameLength is the longest display name, in runes, accepted by DefaultDisplayNameValidator
+const MaxDisplayNameLength = 32
+
+// DefaultDisplayNameValidator accepts names of 1 to MaxDisplayNameLength runes
+func DefaultDisplayNameValidator(displayName string) error {
+	length := utf8.RuneCountInString(displayName)
+	if length == 0 {
+		return errors.New("display name must not be empty")
+	}
+	if length > MaxDisplayNameLength {
+		return fmt.Errorf("display name is %d runes long, max is %d", length, MaxDisplayNameLength)
+	}
+	return nil
+}
+
+// DisplayNameValidator is used by SetDisplayNameValidated, replace it at startup
+// to enforce extra rules such as banned characters
+var DisplayNameValidator func(displayName string) error = DefaultDisplayNameValidator
+
+// ExperienceFloor returns the least experience at which LevelCurve reaches level,
+// assuming the curve never decreases. Unreachable levels return math.MaxInt64
+func ExperienceFloor(level int) int64 {
+	low, high := int64(0), int64(math.MaxInt64)
+	if LevelCurve(high) < level {
+		return high
+	}
+	for low < high {
+		mid := low + (high-low)/2
+		if LevelCurve(mid) >= level {
+			high = mid
+		} else {
+			low = mid + 1
+		}
+	}
+	return low
+}
+
 type UserData struct {
+	lastAccess       int64 // unix nanos, accessed only via sync/atomic, kept first for 64-bit alignment
 	mu               sync.RWMutex
-	UserId           string `json:"uid"`
-	DisplayName      string `json:"display_name"`
-	GameLevel        int    `json:"game_level"`
-	Experience       int64  `json:"experience"`
-	UserInternalData string `json:"-"`
+	UserId           string    `json:"uid"`
+	DisplayName      string    `json:"display_name"`
+	GameLevel        int       `json:"game_level"`
+	Experience       int64     `json:"experience"`
+	UserInternalData string    `json:"-"`
+	UpdatedAt        time.Time `json:"updated_at"` // RFC 3339, see GetUpdatedAt
+
+	onLevelUp     LevelChangeFunc
+	onLevelChange LevelChangeFunc
+	history       experienceRing
+	gainWindow    experienceWindow
+	version       uint64
+	locks         lockCounter
 }
 
+// LevelChangeFunc is notified with the level before and after a change
+type LevelChangeFunc func(u *UserData, oldLevel, newLevel int)
+
 /*
 -- ChatGPT prompt example that can generate protected getters and setters for struct fields
 
@@ -35,78 +104,719 @@ type UserData struct {
 */
 
 func NewUserData(userId string, displayName string, gameLevel int, experience int64) *UserData {
-	return &UserData{
+	userData := &UserData{
 		UserId:      userId,
 		DisplayName: displayName,
 		GameLevel:   gameLevel,
 		Experience:  experience,
+		UpdatedAt:   time.Now(),
+	}
+	userData.touch()
+	return userData
+}
+
+// NewUserDataValidated is NewUserData that rejects an empty userId and a negative
+// gameLevel or experience. A gameLevel that disagrees with LevelCurve(experience) is
+// not an error, it is replaced by the level derived from experience
+func NewUserDataValidated(userId string, displayName string, gameLevel int, experience int64) (*UserData, error) {
+	if err := validateUserFields(userId, gameLevel, experience); err != nil {
+		return nil, fmt.Errorf("new user: %w", err)
 	}
+	return NewUserData(userId, displayName, LevelCurve(experience), experience), nil
+}
+
+// Validate checks that UserId is non-empty and GameLevel and Experience are not negative
+func (u *UserData) Validate() error {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return validateUserFields(u.UserId, u.GameLevel, u.Experience)
+}
+
+func validateUserFields(userId string, gameLevel int, experience int64) error {
+	if userId == "" {
+		return errors.New("empty user id")
+	}
+	if gameLevel < 0 {
+		return fmt.Errorf("user %q: negative game level %d", userId, gameLevel)
+	}
+	if experience < 0 {
+		return fmt.Errorf("user %q: negative experience %d", userId, experience)
+	}
+	return nil
+}
+
+// LastAccess is the last time the user was read or written through its accessors
+// or looked up in the cache
+func (u *UserData) LastAccess() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&u.lastAccess))
+}
+
+// touch records an access, it is atomic so readers holding only RLock may call it
+func (u *UserData) touch() {
+	u.touchAt(time.Now())
+}
+
+func (u *UserData) touchAt(at time.Time) {
+	atomic.StoreInt64(&u.lastAccess, at.UnixNano())
+}
+
+// modified records a mutation for Version and GetUpdatedAt, the caller holds the write lock
+func (u *UserData) modified() {
+	u.version++
+	u.UpdatedAt = time.Now()
+}
+
+func (u *UserData) GetUserId() string {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	u.touch()
+	return u.UserId
 }
 
 func (u *UserData) GetDisplayName() string {
 	u.mu.RLock()
 	defer u.mu.RUnlock()
+	u.touch()
 	return u.DisplayName
 }
 
 func (u *UserData) SetDisplayName(displayName string) {
-	u.mu.Lock()
+	u.lock()
 	defer u.mu.Unlock()
+	u.touch()
+	u.modified()
 	u.DisplayName = displayName
 }
 
+// SetDisplayNameValidated stores displayName only if DisplayNameValidator accepts it
+func (u *UserData) SetDisplayNameValidated(displayName string) error {
+	if err := DisplayNameValidator(displayName); err != nil {
+		return err
+	}
+	u.SetDisplayName(displayName)
+	return nil
+}
+
 func (u *UserData) GetGameLevel() int {
 	u.mu.RLock()
 	defer u.mu.RUnlock()
+	u.touch()
 	return u.GameLevel
 }
 
 func (u *UserData) SetGameLevel(gameLevel int) {
-	u.mu.Lock()
-	defer u.mu.Unlock()
+	u.lock()
+	u.touch()
+	u.modified()
+	oldLevel := u.GameLevel
 	u.GameLevel = gameLevel
+	change := u.levelChangeLocked(oldLevel)
+	u.mu.Unlock()
+	change.notify()
 }
 
 func (u *UserData) GetExperience() int64 {
 	u.mu.RLock()
 	defer u.mu.RUnlock()
+	u.touch()
 	return u.Experience
 }
 
 func (u *UserData) SetExperience(value int64) {
-	u.mu.Lock()
-	defer u.mu.Unlock()
+	u.lock()
+	u.touch()
+	u.modified()
+	oldLevel := u.GameLevel
 	u.Experience = value
+	u.GameLevel = LevelCurve(u.Experience)
+	change := u.levelChangeLocked(oldLevel)
+	u.mu.Unlock()
+	change.notify()
 }
 
-func (u *UserData) ToApi() string {
+// Version counts the mutations made through UserData and cache methods, compare it
+// with a cached value to tell whether the user changed. Reads don't change it
+func (u *UserData) Version() uint64 {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.version
+}
+
+// GetUpdatedAt returns when the user was created or last mutated through UserData or
+// cache methods
+func (u *UserData) GetUpdatedAt() time.Time {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.UpdatedAt
+}
+
+// GetSnapshotFields reads the display name, level and experience under one RLock so
+// the three values are consistent with each other
+func (u *UserData) GetSnapshotFields() (displayName string, level int, xp int64) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	u.touch()
+	return u.DisplayName, u.GameLevel, u.Experience
+}
+
+func (u *UserData) GetInternalData() string {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	u.touch()
+	return u.UserInternalData
+}
+
+func (u *UserData) SetInternalData(internalData string) {
+	u.lock()
+	defer u.mu.Unlock()
+	u.touch()
+	u.modified()
+	u.UserInternalData = internalData
+}
+
+// Reset clears progression and internal data while keeping UserId and DisplayName
+func (u *UserData) Reset() {
+	u.lock()
+	u.touch()
+	u.modified()
+	oldLevel := u.GameLevel
+	u.GameLevel = 0
+	u.Experience = 0
+	u.UserInternalData = ""
+	change := u.levelChangeLocked(oldLevel)
+	u.mu.Unlock()
+	change.notify()
+}
+
+// UserView is a read-only view of a UserData for code that must not mutate it
+type UserView interface {
+	GetUserId() string
+	GetDisplayName() string
+	GetGameLevel() int
+	GetExperience() int64
+}
+
+// View returns u as a UserView, reads go to the same underlying user
+func (u *UserData) View() UserView {
+	return u
+}
+
+// CompareAndSetExperience sets experience to newValue and recomputes the level only
+// if it still equals oldValue, for optimistic retry loops
+func (u *UserData) CompareAndSetExperience(oldValue, newValue int64) bool {
+	u.lock()
+	u.touch()
+	if u.Experience != oldValue {
+		u.mu.Unlock()
+		return false
+	}
+	u.modified()
+	oldLevel := u.GameLevel
+	u.Experience = newValue
+	u.GameLevel = LevelCurve(u.Experience)
+	change := u.levelChangeLocked(oldLevel)
+	u.mu.Unlock()
+	change.notify()
+	return true
+}
+
+// CopyValue returns a point-in-time copy of the user data with a fresh mutex.
+// The copy is independent and won't reflect later mutations of u
+func (u *UserData) CopyValue() UserData {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return UserData{
+		lastAccess:       atomic.LoadInt64(&u.lastAccess),
+		UserId:           u.UserId,
+		DisplayName:      u.DisplayName,
+		GameLevel:        u.GameLevel,
+		Experience:       u.Experience,
+		UserInternalData: u.UserInternalData,
+		UpdatedAt:        u.UpdatedAt,
+		version:          u.version,
+	}
+}
+
+// Equal reports whether both users hold the same UserId, DisplayName, GameLevel,
+// Experience and UserInternalData. other is copied first so only one user lock is
+// held at a time and Equal can't deadlock with writers locking users in UserId order
+func (u *UserData) Equal(other *UserData) bool {
+	if other == nil {
+		return false
+	}
+	if other == u {
+		return true
+	}
+	theirs := other.CopyValue()
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.UserId == theirs.UserId &&
+		u.DisplayName == theirs.DisplayName &&
+		u.GameLevel == theirs.GameLevel &&
+		u.Experience == theirs.Experience &&
+		u.UserInternalData == theirs.UserInternalData
+}
+
+// AddExperience grants experience and recomputes the game level in a single locked
+// read-modify-write, returns the new level. Experience saturates at the int64 bounds
+// instead of wrapping, clamped reports whether that happened
+func (u *UserData) AddExperience(delta int64) (level int, clamped bool) {
+	u.lock()
+	u.touch()
+	clamped, levelUp, change := u.addExperienceLocked(delta)
+	level = u.GameLevel
+	u.mu.Unlock()
+
+	levelUp.notify()
+	change.notify()
+	return level, clamped
+}
+
+// addExperienceLocked applies delta and returns the pending OnLevelUp and
+// OnLevelChange notifications, the caller holds u.mu
+func (u *UserData) addExperienceLocked(delta int64) (clamped bool, levelUp, change levelChange) {
+	u.modified()
+	oldLevel := u.GameLevel
+	u.Experience, clamped = addExperienceClamped(u.Experience, delta)
+	u.GameLevel = LevelCurve(u.Experience)
+	u.history.push(u.Experience)
+	change = u.levelChangeLocked(oldLevel)
+	if u.GameLevel > oldLevel {
+		levelUp = levelChange{userData: u, callback: u.onLevelUp, oldLevel: oldLevel, newLevel: u.GameLevel}
+	}
+	return clamped, levelUp, change
+}
+
+// PromoteLevel changes the level by delta, never going below 0, and moves experience
+// into the new level's range so the two stay consistent: raised to its floor on
+// promotion, lowered to just below the next level's floor on demotion. A promotion
+// never takes experience away. Returns the new level
+func (u *UserData) PromoteLevel(delta int) int {
+	u.lock()
+	u.touch()
+	u.modified()
+	oldLevel := u.GameLevel
+	u.GameLevel += delta
+	if u.GameLevel < 0 {
+		u.GameLevel = 0
+	}
+	if floor := ExperienceFloor(u.GameLevel); u.Experience < floor {
+		u.Experience = floor
+	}
+	if delta < 0 && LevelCurve(u.Experience) > u.GameLevel {
+		u.Experience = ExperienceFloor(u.GameLevel+1) - 1
+	}
+	newLevel := u.GameLevel
+	change := u.levelChangeLocked(oldLevel)
+	u.mu.Unlock()
+	change.notify()
+	return newLevel
+}
+
+// DecayExperience multiplies experience by factor and recomputes the level.
+// factor must be in (0, 1], otherwise an error is returned and nothing changes
+func (u *UserData) DecayExperience(factor float64) error {
+	if err := validateDecayFactor(factor); err != nil {
+		return err
+	}
+	u.decayExperience(factor).notify()
+	return nil
+}
+
+// decayExperience is DecayExperience for a validated factor that returns the pending
+// OnLevelChange notification instead of delivering it
+func (u *UserData) decayExperience(factor float64) levelChange {
+	u.lock()
+	defer u.mu.Unlock()
+	u.touch()
+	u.modified()
+	oldLevel := u.GameLevel
+	u.Experience = int64(float64(u.Experience) * factor)
+	u.GameLevel = LevelCurve(u.Experience)
+	return u.levelChangeLocked(oldLevel)
+}
+
+func validateDecayFactor(factor float64) error {
+	if !(factor > 0 && factor <= 1) {
+		return fmt.Errorf("decay factor must be in (0, 1], got %v", factor)
+	}
+	return nil
+}
+
+func addExperienceClamped(experience int64, delta int64) (int64, bool) {
+	if delta > 0 && experience > math.MaxInt64-delta {
+		return math.MaxInt64, true
+	}
+	if delta < 0 && experience < math.MinInt64-delta {
+		return math.MinInt64, true
+	}
+	return experience + delta, false
+}
+
+// SetOnLevelUp registers a callback fired by AddExperience, TryAddExperience and on the
+// recipient of UsersCache.Gift when the level increases. It runs after the lock is
+// released so it may call back into u
+func (u *UserData) SetOnLevelUp(callback LevelChangeFunc) {
+	u.lock()
+	defer u.mu.Unlock()
+	u.onLevelUp = callback
+}
+
+// SetOnLevelChange registers a callback fired whenever the level changes in either
+// direction through SetGameLevel, SetExperience, AddExperience, TryAddExperience,
+// CompareAndSetExperience, PromoteLevel, DecayExperience, Reset, SetField, ApplyPatch,
+// UsersCache.Transfer, UsersCache.Gift or UsersCache.RecomputeAllLevels. Changes made
+// inside UpdateData closures are not reported. It runs after the lock is released and
+// independently of SetOnLevelUp
+func (u *UserData) SetOnLevelChange(callback LevelChangeFunc) {
+	u.lock()
+	defer u.mu.Unlock()
+	u.onLevelChange = callback
+}
+
+// levelChange is a pending OnLevelChange notification, captured under the lock
+// and delivered with notify after unlocking
+type levelChange struct {
+	userData           *UserData
+	callback           LevelChangeFunc
+	oldLevel, newLevel int
+}
+
+func (u *UserData) levelChangeLocked(oldLevel int) levelChange {
+	return levelChange{userData: u, callback: u.onLevelChange, oldLevel: oldLevel, newLevel: u.GameLevel}
+}
+
+func (c levelChange) notify() {
+	if c.callback != nil && c.oldLevel != c.newLevel {
+		c.callback(c.userData, c.oldLevel, c.newLevel)
+	}
+}
+
+// notifyAll delivers notifications collected under the cache lock, in order,
+// once it is released
+func notifyAll(changes []levelChange) {
+	for _, change := range changes {
+		change.notify()
+	}
+}
+
+// userDataJSON is the lock-free shadow of UserData used for marshalling
+type userDataJSON struct {
+	UserId      string `json:"uid"`
+	DisplayName string `json:"display_name"`
+	GameLevel   int    `json:"game_level"`
+	Experience  int64  `json:"experience"`
+	// UpdatedAt travels with the user so ApplyIfNewer can compare users from other
+	// nodes, it is a pointer so input written without it still parses
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// userData builds the user described by the snapshot. Without updated_at the user
+// counts as modified now, like NewUserData
+func (s userDataJSON) userData() *UserData {
+	userData := NewUserData(s.UserId, s.DisplayName, s.GameLevel, s.Experience)
+	if s.UpdatedAt != nil {
+		userData.UpdatedAt = *s.UpdatedAt
+	}
+	return userData
+}
+
+// MarshalJSON holds the read lock while reading fields, so users embedded
+// in larger responses can be passed to json.Marshal directly
+func (u *UserData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(u.jsonSnapshot())
+}
+
+func (u *UserData) jsonSnapshot() userDataJSON {
 	u.mu.RLock()
 	defer u.mu.RUnlock()
+	return u.jsonSnapshotLocked()
+}
+
+// jsonSnapshotLocked is jsonSnapshot for a caller already holding u's lock
+func (u *UserData) jsonSnapshotLocked() userDataJSON {
+	snapshot := userDataJSON{
+		UserId:      u.UserId,
+		DisplayName: u.DisplayName,
+		GameLevel:   u.GameLevel,
+		Experience:  u.Experience,
+	}
+	if !u.UpdatedAt.IsZero() {
+		updatedAt := u.UpdatedAt
+		snapshot.UpdatedAt = &updatedAt
+	}
+	return snapshot
+}
+
+// ToApi relies on MarshalJSON for locking, RWMutex read locks must not be taken recursively.
+// The output ends with updated_at as an RFC 3339 timestamp, older output without it
+// still parses
+func (u *UserData) ToApi() string {
 	return MustStringify(u)
 }
 
-func MustStringify(obj interface{}) string {
+// ParseUserData is the inverse of ToApi, UpdatedAt is restored from updated_at.
+// UserInternalData is tagged json:"-" so it does not round-trip and is always empty
+// in the parsed user
+func ParseUserData(jsonStr string) (*UserData, error) {
+	var snapshot userDataJSON
+	if err := json.Unmarshal([]byte(jsonStr), &snapshot); err != nil {
+		return nil, fmt.Errorf("parse user data: %w", err)
+	}
+	return snapshot.userData(), nil
+}
+
+// ApplyPatch updates only the fields present in a partial ToApi-style JSON object,
+// e.g. {"display_name":"x"}. Unknown keys are ignored, uid is read-only and wrong
+// value types are rejected, in both cases nothing is applied. A patched experience
+// also recomputes the game level, taking precedence over a patched game_level
+func ApplyPatch(u *UserData, jsonPatch []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(jsonPatch, &fields); err != nil {
+		return fmt.Errorf("parse patch: %w", err)
+	}
+	if _, found := fields["uid"]; found {
+		return errors.New("patch field uid is read-only")
+	}
+	var (
+		displayName *string
+		gameLevel   *int
+		experience  *int64
+	)
+	for key, target := range map[string]interface{}{
+		"display_name": &displayName,
+		"game_level":   &gameLevel,
+		"experience":   &experience,
+	} {
+		if raw, found := fields[key]; found {
+			if err := json.Unmarshal(raw, target); err != nil {
+				return fmt.Errorf("patch field %s: %w", key, err)
+			}
+		}
+	}
+
+	if displayName == nil && gameLevel == nil && experience == nil {
+		return nil
+	}
+
+	u.lock()
+	u.touch()
+	u.modified()
+	oldLevel := u.GameLevel
+	if displayName != nil {
+		u.DisplayName = *displayName
+	}
+	if gameLevel != nil {
+		u.GameLevel = *gameLevel
+	}
+	if experience != nil {
+		u.Experience = *experience
+		u.GameLevel = LevelCurve(u.Experience)
+	}
+	change := u.levelChangeLocked(oldLevel)
+	u.mu.Unlock()
+	change.notify()
+	return nil
+}
+
+// ToApiE is ToApi that reports marshalling errors
+func (u *UserData) ToApiE() (string, error) {
+	return Stringify(u)
+}
+
+func Stringify(obj interface{}) (string, error) {
 	bytea, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	return string(bytea), nil
+}
+
+// MustStringify never panics, it returns "" when obj can't be marshalled,
+// use Stringify to tell that apart from an empty result
+func MustStringify(obj interface{}) string {
+	str, err := Stringify(obj)
 	if err != nil {
 		return ""
 	}
-	return string(bytea)
+	return str
 }
 
 func (u *UserData) UpdateData(operation func(userdata *UserData)) {
-	u.mu.Lock()
+	u.lock()
 	defer u.mu.Unlock()
+	u.touch()
+	u.modified()
 	operation(u)
 }
 
+// UpdateIf runs pred and, only if it returns true, operation within one write-locked
+// section, so the decision can't go stale before the write. Both read and write
+// fields directly. It reports whether operation ran
+func (u *UserData) UpdateIf(pred func(userData *UserData) bool, operation func(userData *UserData)) bool {
+	u.lock()
+	defer u.mu.Unlock()
+	u.touch()
+	if !pred(u) {
+		return false
+	}
+	u.modified()
+	operation(u)
+	return true
+}
+
+// UpdateDataResult is UpdateData returning the operation's result, it is a package
+// function because methods can't have type parameters
+func UpdateDataResult[T any](u *UserData, operation func(userdata *UserData) T) T {
+	u.lock()
+	defer u.mu.Unlock()
+	u.touch()
+	u.modified()
+	return operation(u)
+}
+
 type UsersCache struct {
+	// Counters accessed only via sync/atomic, kept first for 64-bit alignment
+	hits   uint64
+	misses uint64
+	// Bumped on every membership change, see Generation
+	generation uint64
+	// Running sum of experienceById, see TotalExperience
+	totalExperience int64
+
 	mu           sync.RWMutex
 	userDataById map[string]*UserData
+	// Secondary index for display name lookups, kept in sync by cache methods only,
+	// see UpdateDisplayName
+	userIdsByDisplayName map[string]map[string]struct{}
+	displayNameById      map[string]string
+	// Secondary index by game level, refreshed by cache methods only, see CacheAddExperience
+	userIdsByLevel map[int]map[string]struct{}
+	levelById      map[string]int
+	// Last experience seen per user, summed into totalExperience
+	experienceById map[string]int64
+
+	initialCapacity int
+	maxUsers        int
+	janitor         janitor
+	wal             Appender
+	logger          Logger
+	leaderboard     *Leaderboard
+	lru             *lruList
+	events          eventHub
+
+	// Set once at construction, see WithCopyOnWriteSnapshot
+	copyOnWrite bool
+	snapshot    atomic.Pointer[map[string]UserData]
+	// snapshotDirty is set by every insert and delete, unlock republishes the snapshot
+	snapshotDirty bool
 }
 
 func NewUsersCache() *UsersCache {
-	return &UsersCache{
-		userDataById: make(map[string]*UserData),
+	uc := &UsersCache{}
+	uc.resetLocked()
+	return uc
+}
+
+func (uc *UsersCache) resetLocked() {
+	uc.userDataById = make(map[string]*UserData, uc.initialCapacity)
+	uc.userIdsByDisplayName = make(map[string]map[string]struct{})
+	uc.displayNameById = make(map[string]string)
+	uc.userIdsByLevel = make(map[int]map[string]struct{})
+	uc.levelById = make(map[string]int)
+	uc.experienceById = make(map[string]int64)
+	atomic.StoreInt64(&uc.totalExperience, 0)
+	uc.leaderboard.reset()
+	uc.lru.reset()
+	uc.publishSnapshotLocked()
+}
+
+// insertLocked stores the user under userId and indexes it, replacing any previous entry
+func (uc *UsersCache) insertLocked(userId string, userData *UserData) {
+	uc.deleteLocked(userId)
+	uc.userDataById[userId] = userData
+	uc.indexDisplayNameLocked(userId, userData.GetDisplayName())
+	uc.indexLevelLocked(userId, userData.GetGameLevel())
+	uc.trackExperienceLocked(userId, userData.experience())
+	uc.leaderboard.update(userId, userData)
+	uc.lru.add(userId)
+	atomic.AddUint64(&uc.generation, 1)
+	uc.snapshotDirty = true
+	uc.evictLRULocked()
+}
+
+// mutations collects the ids a write-locked method added or removed, so subscribers
+// and the logger hear about them once unlockAndReport releases the lock
+type mutations struct {
+	added   []string
+	removed []string
+}
+
+// addLocked inserts the user like insertLocked and journals the add
+func (uc *UsersCache) addLocked(m *mutations, userId string, userData *UserData) {
+	uc.insertLocked(userId, userData)
+	uc.appendWALLocked(WALOpAdd, userId, userData)
+	m.added = append(m.added, userId)
+}
+
+// removeLocked deletes the user like deleteLocked and journals the removal,
+// reports whether it existed
+func (uc *UsersCache) removeLocked(m *mutations, userId string) bool {
+	if !uc.deleteLocked(userId) {
+		return false
+	}
+	uc.appendWALLocked(WALOpRemove, userId, nil)
+	m.removed = append(m.removed, userId)
+	return true
+}
+
+// unlockAndReport releases uc.mu, then publishes and logs the collected removals
+// followed by the additions
+func (uc *UsersCache) unlockAndReport(m *mutations) {
+	logger := uc.logger
+	uc.unlock()
+	uc.events.publish(CacheEventRemove, m.removed...)
+	logMutations(logger, WALOpRemove, m.removed...)
+	uc.events.publish(CacheEventAdd, m.added...)
+	logMutations(logger, WALOpAdd, m.added...)
+}
+
+// deleteLocked removes the user with userId and its index entries, reports whether it existed
+func (uc *UsersCache) deleteLocked(userId string) bool {
+	if _, found := uc.userDataById[userId]; !found {
+		return false
+	}
+	delete(uc.userDataById, userId)
+	uc.unindexDisplayNameLocked(userId)
+	uc.unindexLevelLocked(userId)
+	uc.untrackExperienceLocked(userId)
+	uc.leaderboard.remove(userId)
+	uc.lru.remove(userId)
+	atomic.AddUint64(&uc.generation, 1)
+	uc.snapshotDirty = true
+	return true
+}
+
+func (uc *UsersCache) indexDisplayNameLocked(userId string, displayName string) {
+	ids, found := uc.userIdsByDisplayName[displayName]
+	if !found {
+		ids = make(map[string]struct{})
+		uc.userIdsByDisplayName[displayName] = ids
+	}
+	ids[userId] = struct{}{}
+	uc.displayNameById[userId] = displayName
+}
+
+func (uc *UsersCache) unindexDisplayNameLocked(userId string) {
+	displayName, found := uc.displayNameById[userId]
+	if !found {
+		return
+	}
+	delete(uc.displayNameById, userId)
+	ids := uc.userIdsByDisplayName[displayName]
+	delete(ids, userId)
+	if len(ids) == 0 {
+		delete(uc.userIdsByDisplayName, displayName)
 	}
 }
 
@@ -114,15 +824,535 @@ func (uc *UsersCache) GetUserData(userId string) (*UserData, bool) {
 	uc.mu.RLock()
 	defer uc.mu.RUnlock()
 	userData, found := uc.userDataById[userId]
+	if found {
+		atomic.AddUint64(&uc.hits, 1)
+		userData.touch()
+		uc.lru.touch(userId)
+	} else {
+		atomic.AddUint64(&uc.misses, 1)
+	}
 	return userData, found
 }
 
+// GetMultiple looks up several users under a single read lock and splits the ids
+// into found users and missing ids
+func (uc *UsersCache) GetMultiple(userIds ...string) (found map[string]*UserData, missing []string) {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+	found = make(map[string]*UserData, len(userIds))
+	for _, userId := range userIds {
+		if userData, ok := uc.userDataById[userId]; ok {
+			userData.touch()
+			found[userId] = userData
+		} else {
+			missing = append(missing, userId)
+		}
+	}
+	return found, missing
+}
+
+// CacheMetrics returns how many GetUserData lookups found or missed a user
+func (uc *UsersCache) CacheMetrics() (hits, misses uint64) {
+	return atomic.LoadUint64(&uc.hits), atomic.LoadUint64(&uc.misses)
+}
+
 func (uc *UsersCache) AddUserData(users ...*UserData) {
+	var m mutations
 	uc.mu.Lock()
-	defer uc.mu.Unlock()
 	for _, user := range users {
-		uc.userDataById[user.UserId] = user
+		uc.addLocked(&m, user.GetUserId(), user)
+	}
+	uc.unlockAndReport(&m)
+}
+
+// AddUserDataUnique inserts users only if none of their ids is cached or repeated in
+// the batch. On conflict nothing is inserted and the error names the first conflicting id
+func (uc *UsersCache) AddUserDataUnique(users ...*UserData) (added int, err error) {
+	userIds := make([]string, 0, len(users))
+	seen := make(map[string]struct{}, len(users))
+	uc.mu.Lock()
+	for _, user := range users {
+		userId := user.GetUserId()
+		_, cached := uc.userDataById[userId]
+		_, repeated := seen[userId]
+		if cached || repeated {
+			uc.unlock()
+			return 0, fmt.Errorf("%w: %q", ErrUserExists, userId)
+		}
+		seen[userId] = struct{}{}
+		userIds = append(userIds, userId)
+	}
+	var m mutations
+	for i, user := range users {
+		uc.addLocked(&m, userIds[i], user)
+	}
+	uc.unlockAndReport(&m)
+	return len(users), nil
+}
+
+// SetMaxUsers caps the number of users TryAddUserData may hold, 0 means unlimited.
+// Other insert methods ignore the cap, unless the cache was built WithLRU: then every
+// insert past the cap, and lowering the cap, evicts the least recently used users
+func (uc *UsersCache) SetMaxUsers(maxUsers int) {
+	uc.mu.Lock()
+	defer uc.unlock()
+	uc.maxUsers = maxUsers
+	uc.evictLRULocked()
+}
+
+// TryAddUserData is AddUserData that refuses the whole batch when the new ids would
+// grow the cache past SetMaxUsers. Replacing a cached id does not count against the cap.
+// With WithLRU it never refuses and evicts instead
+func (uc *UsersCache) TryAddUserData(users ...*UserData) error {
+	userIds := make([]string, 0, len(users))
+	added := make(map[string]struct{}, len(users))
+	uc.mu.Lock()
+	for _, user := range users {
+		userId := user.GetUserId()
+		userIds = append(userIds, userId)
+		if _, cached := uc.userDataById[userId]; cached {
+			continue
+		}
+		added[userId] = struct{}{}
+		if uc.lru == nil && uc.maxUsers > 0 && len(uc.userDataById)+len(added) > uc.maxUsers {
+			uc.unlock()
+			return fmt.Errorf("%w: %q", ErrCacheFull, userId)
+		}
+	}
+	var m mutations
+	for i, user := range users {
+		uc.addLocked(&m, userIds[i], user)
+	}
+	uc.unlockAndReport(&m)
+	return nil
+}
+
+// ApplyIfNewer stores incoming in place of the cached user with the same UserId when
+// incoming was updated more recently, last write wins. A user that isn't cached yet is
+// always stored. It reports whether incoming was stored
+func (uc *UsersCache) ApplyIfNewer(incoming *UserData) bool {
+	userId := incoming.GetUserId()
+	incomingAt := incoming.GetUpdatedAt()
+	uc.mu.Lock()
+	if existing, found := uc.userDataById[userId]; found && !incomingAt.After(existing.GetUpdatedAt()) {
+		uc.unlock()
+		return false
+	}
+	var m mutations
+	uc.addLocked(&m, userId, incoming)
+	uc.unlockAndReport(&m)
+	return true
+}
+
+// GetOrCreate returns the cached user or inserts the one built by factory, the
+// check and insert happen under one write lock. created reports whether factory was used
+func (uc *UsersCache) GetOrCreate(userId string, factory func() *UserData) (userData *UserData, created bool) {
+	var m mutations
+	uc.mu.Lock()
+	defer uc.unlockAndReport(&m)
+	if userData, found := uc.userDataById[userId]; found {
+		return userData, false
+	}
+	userData = factory()
+	uc.addLocked(&m, userId, userData)
+	return userData, true
+}
+
+// GetByDisplayName returns all users indexed under displayName, names are not unique.
+// The index only sees renames done through UpdateDisplayName
+func (uc *UsersCache) GetByDisplayName(displayName string) ([]*UserData, bool) {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+	ids := uc.userIdsByDisplayName[displayName]
+	if len(ids) == 0 {
+		return nil, false
+	}
+	res := make([]*UserData, 0, len(ids))
+	for userId := range ids {
+		res = append(res, uc.userDataById[userId])
+	}
+	return res, true
+}
+
+// UpdateDisplayName renames a cached user and updates the display name index.
+// Calling SetDisplayName directly on a cached user bypasses the index and leaves
+// GetByDisplayName stale, use this method instead
+func (uc *UsersCache) UpdateDisplayName(userId string, displayName string) bool {
+	uc.mu.Lock()
+	defer uc.unlock()
+	userData, found := uc.userDataById[userId]
+	if !found {
+		return false
+	}
+	userData.SetDisplayName(displayName)
+	uc.unindexDisplayNameLocked(userId)
+	uc.indexDisplayNameLocked(userId, displayName)
+	uc.lru.touch(userId)
+	return true
+}
+
+// RenameUser is UpdateDisplayName returning ErrUserNotFound for a missing user
+func (uc *UsersCache) RenameUser(userId string, newName string) error {
+	if !uc.UpdateDisplayName(userId, newName) {
+		return fmt.Errorf("%w: %q", ErrUserNotFound, userId)
+	}
+	return nil
+}
+
+// SwapDisplayNames exchanges two cached users' display names in one step, so no reader
+// sees both with the same name, and updates the display name index
+func (uc *UsersCache) SwapDisplayNames(idA string, idB string) error {
+	uc.mu.Lock()
+	defer uc.unlock()
+	userA, found := uc.userDataById[idA]
+	if !found {
+		return fmt.Errorf("%w: %q", ErrUserNotFound, idA)
+	}
+	userB, found := uc.userDataById[idB]
+	if !found {
+		return fmt.Errorf("%w: %q", ErrUserNotFound, idB)
+	}
+	if idA == idB {
+		return nil
+	}
+
+	first, second := userA, userB
+	if idB < idA {
+		first, second = userB, userA
+	}
+	first.lock()
+	second.lock()
+	userA.touch()
+	userB.touch()
+	userA.DisplayName, userB.DisplayName = userB.DisplayName, userA.DisplayName
+	userA.modified()
+	userB.modified()
+	nameA, nameB := userA.DisplayName, userB.DisplayName
+	second.mu.Unlock()
+	first.mu.Unlock()
+
+	uc.unindexDisplayNameLocked(idA)
+	uc.unindexDisplayNameLocked(idB)
+	uc.indexDisplayNameLocked(idA, nameA)
+	uc.indexDisplayNameLocked(idB, nameB)
+	return nil
+}
+
+// WithUser is the safe way to act on a single cached user, op is skipped and false
+// returned if the user is absent. Unlike UpdateUserData no user lock is held while
+// op runs, so op should use the locking accessors
+func (uc *UsersCache) WithUser(userId string, operation func(userData *UserData)) bool {
+	userData, found := uc.GetUserData(userId)
+	if !found {
+		return false
+	}
+	operation(userData)
+	return true
+}
+
+// UpdateUserData runs op under the user's lock, returns false without calling op
+// if the user is not cached
+func (uc *UsersCache) UpdateUserData(userId string, operation func(userData *UserData)) bool {
+	uc.mu.RLock()
+	userData, found := uc.userDataById[userId]
+	if !found {
+		uc.mu.RUnlock()
+		return false
+	}
+	userData.lock()
+	userData.touch()
+	userData.modified()
+	operation(userData)
+	uc.appendUpdateLocked(userId, userData)
+	userData.mu.Unlock()
+	uc.lru.touch(userId)
+	logger := uc.logger
+	uc.mu.RUnlock()
+	uc.reindexLevels(userId)
+	logMutations(logger, WALOpUpdate, userId)
+	return true
+}
+
+// BatchUpdate applies op under each found user's lock while holding the cache read
+// lock for the whole batch, missing ids are skipped. Returns how many users were updated
+func (uc *UsersCache) BatchUpdate(userIds []string, operation func(userData *UserData)) (updated int) {
+	uc.mu.RLock()
+	for _, userId := range userIds {
+		if userData, found := uc.userDataById[userId]; found {
+			userData.UpdateData(operation)
+			updated++
+		}
+	}
+	uc.mu.RUnlock()
+	uc.reindexLevels(userIds...)
+	return updated
+}
+
+// Transfer moves amount experience from one user to another and recomputes both levels.
+// The recipient saturates at math.MaxInt64 like AddExperience.
+// The two user locks are always taken in UserId order, so concurrent opposite
+// transfers can't deadlock
+func (uc *UsersCache) Transfer(fromId string, toId string, amount int64) error {
+	fromChange, toChange, err := uc.transfer(fromId, toId, amount)
+	if err != nil {
+		return err
+	}
+	uc.lru.touch(fromId)
+	uc.lru.touch(toId)
+	uc.reindexLevels(fromId, toId)
+	fromChange.notify()
+	toChange.notify()
+	return nil
+}
+
+// transfer moves the experience and returns the pending OnLevelChange notifications
+// of both users, for the caller to deliver once every lock is released
+func (uc *UsersCache) transfer(fromId string, toId string, amount int64) (fromChange, toChange levelChange, err error) {
+	if amount <= 0 {
+		return fromChange, toChange, fmt.Errorf("transfer amount must be positive, got %d", amount)
+	}
+	if fromId == toId {
+		return fromChange, toChange, fmt.Errorf("cannot transfer from user %q to itself", fromId)
+	}
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+	from, found := uc.userDataById[fromId]
+	if !found {
+		return fromChange, toChange, fmt.Errorf("%w: %q", ErrUserNotFound, fromId)
+	}
+	to, found := uc.userDataById[toId]
+	if !found {
+		return fromChange, toChange, fmt.Errorf("%w: %q", ErrUserNotFound, toId)
+	}
+
+	first, second := from, to
+	if toId < fromId {
+		first, second = to, from
+	}
+	first.lock()
+	defer first.mu.Unlock()
+	second.lock()
+	defer second.mu.Unlock()
+
+	if from.Experience < amount {
+		return fromChange, toChange, fmt.Errorf("%w: user %q has %d, cannot transfer %d", ErrInsufficientExperience, fromId, from.Experience, amount)
+	}
+	fromLevel, toLevel := from.GameLevel, to.GameLevel
+	from.Experience -= amount
+	from.GameLevel = LevelCurve(from.Experience)
+	to.Experience, _ = addExperienceClamped(to.Experience, amount)
+	to.GameLevel = LevelCurve(to.Experience)
+	from.modified()
+	to.modified()
+	return from.levelChangeLocked(fromLevel), to.levelChangeLocked(toLevel), nil
+}
+
+// AddExperienceWhere grants delta experience to every user matching pred and returns
+// how many were boosted. pred runs without the user lock held, so it reads through the
+// locking getters and a concurrent writer may change the user between check and grant
+func (uc *UsersCache) AddExperienceWhere(pred func(userData *UserData) bool, delta int64) (affected int) {
+	var (
+		userIds []string
+		changes []levelChange
+	)
+	uc.mu.RLock()
+	for userId, userData := range uc.userDataById {
+		if pred(userData) {
+			userData.lock()
+			userData.touch()
+			_, levelUp, change := userData.addExperienceLocked(delta)
+			userData.mu.Unlock()
+			changes = append(changes, levelUp, change)
+			userIds = append(userIds, userId)
+		}
+	}
+	uc.mu.RUnlock()
+	uc.reindexLevels(userIds...)
+	notifyAll(changes)
+	return len(userIds)
+}
+
+// DecayAll applies DecayExperience to every cached user, see DecayExperience for valid factors
+func (uc *UsersCache) DecayAll(factor float64) error {
+	if err := validateDecayFactor(factor); err != nil {
+		return err
+	}
+	var changes []levelChange
+	uc.mu.RLock()
+	for _, userData := range uc.userDataById {
+		changes = append(changes, userData.decayExperience(factor))
+	}
+	uc.mu.RUnlock()
+	uc.reindexLevels(uc.Keys()...)
+	notifyAll(changes)
+	return nil
+}
+
+// LockUsers write-locks the found users in UserId order, the primitive for multi-user
+// transactions such as party or guild updates. It returns the unlock function and the
+// found users in UserId order, missing and repeated ids are skipped.
+// Until unlock is called, read and write the returned users' fields directly: calling
+// their locking methods or any cache method from the holder goroutine can deadlock
+func (uc *UsersCache) LockUsers(userIds ...string) (func(), []*UserData) {
+	sorted := append([]string(nil), userIds...)
+	sort.Strings(sorted)
+
+	uc.mu.RLock()
+	users := make([]*UserData, 0, len(sorted))
+	for i, userId := range sorted {
+		if i > 0 && userId == sorted[i-1] {
+			continue
+		}
+		if userData, found := uc.userDataById[userId]; found {
+			userData.lock()
+			users = append(users, userData)
+		}
+	}
+	uc.mu.RUnlock()
+
+	unlock := func() {
+		for i := len(users) - 1; i >= 0; i-- {
+			users[i].mu.Unlock()
+		}
 	}
+	return unlock, users
+}
+
+// Generation changes whenever a user is added, replaced or removed, or the cache is
+// cleared, so a client can tell its cached list is stale. Field updates on cached users
+// don't change it, use UserData.Version for those
+func (uc *UsersCache) Generation() uint64 {
+	return atomic.LoadUint64(&uc.generation)
+}
+
+// Len returns the number of cached users without copying them
+func (uc *UsersCache) Len() int {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+	return len(uc.userDataById)
+}
+
+// RemoveUserData deletes the user from the cache and reports whether it was present
+func (uc *UsersCache) RemoveUserData(userId string) bool {
+	var m mutations
+	uc.mu.Lock()
+	if !uc.removeLocked(&m, userId) {
+		uc.unlock()
+		return false
+	}
+	uc.unlockAndReport(&m)
+	return true
+}
+
+func (uc *UsersCache) Clear() {
+	uc.mu.Lock()
+	defer uc.unlock()
+	uc.resetLocked()
+	atomic.AddUint64(&uc.generation, 1)
+}
+
+// Drain empties the cache and returns the users it held, for shutdown handoff
+func (uc *UsersCache) Drain() []*UserData {
+	uc.mu.Lock()
+	defer uc.unlock()
+	users := make([]*UserData, 0, len(uc.userDataById))
+	for _, userData := range uc.userDataById {
+		users = append(users, userData)
+	}
+	uc.resetLocked()
+	atomic.AddUint64(&uc.generation, 1)
+	return users
+}
+
+// Compact reallocates the user map and the per-id indexes at their current size so
+// the memory held by deleted entries can be reclaimed, e.g. after a mass eviction
+func (uc *UsersCache) Compact() {
+	uc.mu.Lock()
+	defer uc.unlock()
+	uc.userDataById = compactMap(uc.userDataById)
+	uc.displayNameById = compactMap(uc.displayNameById)
+	uc.levelById = compactMap(uc.levelById)
+	uc.experienceById = compactMap(uc.experienceById)
+}
+
+func compactMap[V any](m map[string]V) map[string]V {
+	res := make(map[string]V, len(m))
+	for key, value := range m {
+		res[key] = value
+	}
+	return res
+}
+
+// Clone returns an independent cache holding deep copies of all users, mutating
+// either cache never affects the other. The WAL, janitor and user callbacks are not copied
+func (uc *UsersCache) Clone() *UsersCache {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+	clone := NewUsersCache()
+	for userId, userData := range uc.userDataById {
+		userCopy := userData.CopyValue()
+		clone.insertLocked(userId, &userCopy)
+	}
+	return clone
+}
+
+// MergeFrom inserts every user of other into uc. On a UserId collision onConflict
+// picks the user to keep, nil onConflict keeps the incoming one. Merged users are shared
+// with other, not copied. Both caches are locked in address order so two caches merging
+// into each other concurrently can't deadlock
+func (uc *UsersCache) MergeFrom(other *UsersCache, onConflict func(existing, incoming *UserData) *UserData) {
+	if other == uc {
+		return
+	}
+	if uintptr(unsafe.Pointer(uc)) < uintptr(unsafe.Pointer(other)) {
+		uc.mu.Lock()
+		other.mu.RLock()
+	} else {
+		other.mu.RLock()
+		uc.mu.Lock()
+	}
+	var m mutations
+	for userId, incoming := range other.userDataById {
+		winner := incoming
+		if existing, found := uc.userDataById[userId]; found {
+			if onConflict != nil {
+				winner = onConflict(existing, incoming)
+			}
+			if winner == existing {
+				continue
+			}
+		}
+		uc.addLocked(&m, userId, winner)
+	}
+	other.mu.RUnlock()
+	uc.unlockAndReport(&m)
+}
+
+// SetUserId changes the id of a cached user and moves it to the new map key,
+// so the struct field and the cache index never disagree
+func (uc *UsersCache) SetUserId(userId string, newId string) error {
+	var m mutations
+	uc.mu.Lock()
+	defer uc.unlockAndReport(&m)
+	userData, found := uc.userDataById[userId]
+	if !found {
+		return fmt.Errorf("%w: %q", ErrUserNotFound, userId)
+	}
+	if newId == userId {
+		return nil
+	}
+	if newId == "" {
+		return errors.New("user id must not be empty")
+	}
+	if _, exists := uc.userDataById[newId]; exists {
+		return fmt.Errorf("%w: %q", ErrUserExists, newId)
+	}
+	uc.removeLocked(&m, userId)
+	userData.lock()
+	userData.UserId = newId
+	userData.mu.Unlock()
+	uc.addLocked(&m, newId, userData)
+	return nil
 }
 
 // -- Example operations on cache
@@ -137,26 +1367,121 @@ func (uc *UsersCache) PerformReadOperation(operation func(userData *UserData)) {
 	}
 }
 
+// PerformReadOperationE is PerformReadOperation that stops at the first error returned
+// by operation and returns it
+func (uc *UsersCache) PerformReadOperationE(operation func(userData *UserData) error) error {
+	return uc.PerformReadOperationCtx(context.Background(), operation)
+}
+
+// ForEach visits users until operation returns false, like sync.Map.Range.
+// The cache read lock is held for the whole iteration
+func (uc *UsersCache) ForEach(operation func(userData *UserData) bool) {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+	for _, userData := range uc.userDataById {
+		if !operation(userData) {
+			return
+		}
+	}
+}
+
+// SnapshotForEach runs operation on copies of the users taken under a brief read lock,
+// no lock is held while it runs so writers are not blocked. operation sees the cache as
+// it was when the snapshot was taken, changes to the copies are not written back.
+// Copies are passed by pointer because UserData holds a mutex
+func (uc *UsersCache) SnapshotForEach(operation func(userData *UserData)) {
+	snapshot := uc.GetSnapshot()
+	for i := range snapshot {
+		operation(&snapshot[i])
+	}
+}
+
+// PerformReadOperationCtx is PerformReadOperation that stops early when ctx is done
+// or operation returns an error, the ctx or operation error is returned
+func (uc *UsersCache) PerformReadOperationCtx(ctx context.Context, operation func(userData *UserData) error) error {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+	for _, userData := range uc.userDataById {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if err := operation(userData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSafeCopySlice returns a new slice of the cached pointers, the users themselves are shared
+// with the cache, use GetSnapshot to get independent copies
 func (uc *UsersCache) GetSafeCopySlice() []*UserData {
 	uc.mu.RLock()
 	defer uc.mu.RUnlock()
-	res := make([]*UserData, len(uc.userDataById))
+	res := make([]*UserData, 0, len(uc.userDataById))
 	for _, userData := range uc.userDataById {
 		res = append(res, userData)
 	}
 	return res
 }
 
+// GetSnapshot returns point-in-time value copies of all users, later mutations
+// of cached users are not reflected in the returned copies
+func (uc *UsersCache) GetSnapshot() []UserData {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+	res := make([]UserData, 0, len(uc.userDataById))
+	for _, userData := range uc.userDataById {
+		res = append(res, userData.CopyValue())
+	}
+	return res
+}
+
+// TopByExperience returns copies of the n users with the most experience, ties are
+// ordered by UserId. n larger than the cache returns everyone, n <= 0 returns none
+func (uc *UsersCache) TopByExperience(n int) []UserData {
+	if n <= 0 {
+		return []UserData{}
+	}
+	res := uc.GetSnapshot()
+	sortByExperience(res)
+	if n < len(res) {
+		res = res[:n]
+	}
+	return res
+}
+
+// sortByExperience orders copies by descending experience, ties by UserId
+func sortByExperience(users []UserData) {
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Experience != users[j].Experience {
+			return users[i].Experience > users[j].Experience
+		}
+		return users[i].UserId < users[j].UserId
+	})
+}
+
 func (uc *UsersCache) MapReduceUsersWithFilter(
 	filter func(userData *UserData) bool,
 	mapper func(userData *UserData) interface{},
 	reducer func([]interface{}) interface{},
 ) interface{} {
+	return MapReduce(uc, filter, mapper, reducer)
+}
+
+// MapReduce is the typed form of MapReduceUsersWithFilter, no type assertions needed
+func MapReduce[M any, R any](
+	uc *UsersCache,
+	filter func(userData *UserData) bool,
+	mapper func(userData *UserData) M,
+	reducer func([]M) R,
+) R {
 	uc.mu.RLock()
 	defer uc.mu.RUnlock()
 
 	// Map phase with filtering
-	mappedResults := make([]interface{}, 0)
+	mappedResults := make([]M, 0)
 	for _, userData := range uc.userDataById {
 		if filter(userData) {
 			result := mapper(userData)
@@ -196,50 +1521,55 @@ func levelCountReducer(results []interface{}) interface{} {
 	return levelCounts
 }
 
-func LoadUsersDataFromDB(usersCache *UsersCache) error {
-	// Mock for actual implementation
-	usersCache.AddUserData(
+// UserSource is where LoadUsersDataFromDB reads users from, e.g. a database
+type UserSource interface {
+	LoadAll() ([]*UserData, error)
+}
+
+// MockUserSource serves a fixed set of four users
+type MockUserSource struct{}
+
+func (MockUserSource) LoadAll() ([]*UserData, error) {
+	return []*UserData{
 		NewUserData("uid_001", "king", 1, 100),
 		NewUserData("uid_002", "queen", 1, 110),
 		NewUserData("uid_003", "soldier", 1, 120),
 		NewUserData("uid_004", "John", 1, 120),
-	)
+	}, nil
+}
+
+func LoadUsersDataFromDB(usersCache Cache, src UserSource) error {
+	users, err := src.LoadAll()
+	if err != nil {
+		return fmt.Errorf("load users: %w", err)
+	}
+	usersCache.AddUserData(users...)
 	return nil
 }
 
+// LoadWithRetry is LoadUsersDataFromDB that retries a failing source up to attempts
+// times in total, sleeping backoff before the first retry and doubling it after each.
+// Fewer than one attempt counts as one. It returns the last error when every attempt fails
+func LoadWithRetry(usersCache Cache, src UserSource, attempts int, backoff time.Duration) error {
+	var err error
+	for attempt := 0; attempt < attempts || attempt == 0; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = LoadUsersDataFromDB(usersCache, src); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGINT)
+	defer stop()
+
 	usersCache := NewUsersCache()
-	_ = LoadUsersDataFromDB(usersCache)
-	for i := 0; i < 100; i++ {
-		// iterationId := i
-		go usersCache.PerformReadOperation(func(userData *UserData) {
-			userData.ToApi()
-			userData.UpdateData(func(userdata *UserData) {
-				userdata.Experience += 10
-				userdata.GameLevel = int(userdata.Experience / 100)
-			})
-		})
-		go func() {
-			u, _ := usersCache.GetUserData("uid_001")
-			u.SetExperience(199)
-		}()
-		go func() {
-			u, _ := usersCache.GetUserData("uid_001")
-			u.UpdateData(func(userdata *UserData) {
-				userdata.Experience += 10
-				userdata.GameLevel = int(userdata.Experience / 100)
-			})
-		}()
-	}
-
-	levelCounts := usersCache.MapReduceUsersWithFilter(excludeJohnFilter, userLevelMapper, levelCountReducer)
-
-	for level, count := range levelCounts.(map[int]int) {
-		fmt.Printf("Level %d: %d users\n", level, count)
-	}
-
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt, syscall.SIGINT)
-	<-interrupt
+	_ = LoadUsersDataFromDB(usersCache, MockUserSource{})
+	_ = Run(ctx, usersCache)
 	fmt.Println("Stopping server..")
 }
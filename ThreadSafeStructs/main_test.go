@@ -0,0 +1,1625 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newLoadedCache(t *testing.T) *UsersCache {
+	t.Helper()
+	usersCache := NewUsersCache()
+	if err := LoadUsersDataFromDB(usersCache, MockUserSource{}); err != nil {
+		t.Fatalf("LoadUsersDataFromDB: %v", err)
+	}
+	return usersCache
+}
+
+// toApiFields is ToApi without updated_at, for comparing against fixed strings
+func toApiFields(u *UserData) string {
+	snapshot := u.jsonSnapshot()
+	snapshot.UpdatedAt = nil
+	return MustStringify(snapshot)
+}
+
+func TestGetSafeCopySlice(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	users := usersCache.GetSafeCopySlice()
+	if len(users) != 4 {
+		t.Fatalf("len = %d, want 4", len(users))
+	}
+	for i, userData := range users {
+		if userData == nil {
+			t.Fatalf("users[%d] is nil", i)
+		}
+	}
+}
+
+func TestRemoveUserData(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	if !usersCache.RemoveUserData("uid_001") {
+		t.Fatal("RemoveUserData(uid_001) = false, want true")
+	}
+	if _, found := usersCache.GetUserData("uid_001"); found {
+		t.Fatal("uid_001 still cached after removal")
+	}
+	if usersCache.RemoveUserData("uid_001") {
+		t.Fatal("RemoveUserData on absent id = true, want false")
+	}
+	if got := usersCache.Len(); got != 3 {
+		t.Fatalf("Len = %d, want 3", got)
+	}
+}
+
+func TestClear(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	usersCache.Clear()
+	if got := usersCache.Len(); got != 0 {
+		t.Fatalf("Len after Clear = %d, want 0", got)
+	}
+}
+
+func TestLen(t *testing.T) {
+	usersCache := NewUsersCache()
+	usersCache.AddUserData(
+		NewUserData("uid_001", "king", 1, 100),
+		NewUserData("uid_002", "queen", 1, 110),
+	)
+	if got := usersCache.Len(); got != 2 {
+		t.Fatalf("Len = %d, want 2", got)
+	}
+	usersCache.RemoveUserData("uid_001")
+	if got := usersCache.Len(); got != 1 {
+		t.Fatalf("Len after remove = %d, want 1", got)
+	}
+}
+
+func TestAddExperience(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 1, 150)
+	if level, _ := userData.AddExperience(60); level != 2 {
+		t.Fatalf("level = %d, want 2", level)
+	}
+	if got := userData.GetExperience(); got != 210 {
+		t.Fatalf("experience = %d, want 210", got)
+	}
+}
+
+func TestAddExperienceInterleave(t *testing.T) {
+	t.Run("split update", func(t *testing.T) {
+		// the old pattern reads experience and writes it back in two locked steps, the
+		// channels force a concurrent AddExperience to land between them
+		userData := NewUserData("uid_001", "king", 0, 190)
+		read, added := make(chan struct{}), make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			experience := userData.GetExperience()
+			close(read)
+			<-added
+			userData.SetExperience(experience + 10)
+		}()
+		go func() {
+			defer wg.Done()
+			<-read
+			userData.AddExperience(10)
+			close(added)
+		}()
+		wg.Wait()
+		if got := userData.GetExperience(); got != 200 {
+			t.Fatalf("experience = %d, want the lost update to leave 200", got)
+		}
+	})
+
+	t.Run("AddExperience", func(t *testing.T) {
+		userData := NewUserData("uid_001", "king", 0, 0)
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				userData.AddExperience(10)
+			}()
+			go func() {
+				defer wg.Done()
+				userData.UpdateData(func(u *UserData) {
+					if u.GameLevel != LevelCurve(u.Experience) {
+						t.Errorf("level %d is stale for experience %d", u.GameLevel, u.Experience)
+					}
+				})
+			}()
+		}
+		wg.Wait()
+		if got := userData.GetExperience(); got != 1000 {
+			t.Fatalf("experience = %d, want 1000", got)
+		}
+		if got := userData.GetGameLevel(); got != LevelCurve(1000) {
+			t.Fatalf("level = %d, want %d", got, LevelCurve(1000))
+		}
+	})
+}
+
+func TestGetSnapshotFieldsConsistent(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 0, 0)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			userData.AddExperience(7)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		displayName, level, xp := userData.GetSnapshotFields()
+		if displayName != "king" || level != LevelCurve(xp) {
+			t.Fatalf("inconsistent fields: %q level %d experience %d", displayName, level, xp)
+		}
+	}
+}
+
+func TestLevelCurve(t *testing.T) {
+	defer func(curve func(int64) int) { LevelCurve = curve }(LevelCurve)
+	LevelCurve = func(experience int64) int {
+		return int(math.Sqrt(float64(experience / 50)))
+	}
+
+	userData := NewUserData("uid_001", "king", 0, 0)
+	userData.SetExperience(800)
+	if got := userData.GetGameLevel(); got != 4 {
+		t.Fatalf("level = %d, want 4", got)
+	}
+	if got, _ := userData.AddExperience(450); got != 5 {
+		t.Fatalf("level after AddExperience = %d, want 5", got)
+	}
+}
+
+func TestParseUserData(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 1, 100)
+	userData.UserInternalData = "secret"
+
+	parsed, err := ParseUserData(userData.ToApi())
+	if err != nil {
+		t.Fatalf("ParseUserData: %v", err)
+	}
+	if parsed.GetUserId() != "uid_001" || parsed.GetDisplayName() != "king" ||
+		parsed.GetGameLevel() != 1 || parsed.GetExperience() != 100 {
+		t.Fatalf("round trip mismatch: %s", parsed.ToApi())
+	}
+	if parsed.UserInternalData != "" {
+		t.Fatalf("UserInternalData = %q, want empty", parsed.UserInternalData)
+	}
+	if !parsed.GetUpdatedAt().Equal(userData.GetUpdatedAt()) {
+		t.Fatalf("UpdatedAt = %v, want %v", parsed.GetUpdatedAt(), userData.GetUpdatedAt())
+	}
+
+	if _, err := ParseUserData(`{"uid":`); err == nil {
+		t.Fatal("ParseUserData on malformed input returned nil error")
+	}
+}
+
+func TestMarshalJSONConcurrentWithSetExperience(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 1, 100)
+	response := struct {
+		User *UserData `json:"user"`
+	}{User: userData}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			userData.SetExperience(int64(i))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			if _, err := json.Marshal(response); err != nil {
+				t.Errorf("json.Marshal: %v", err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+func TestStringify(t *testing.T) {
+	unmarshalable := struct {
+		Events chan int `json:"events"`
+	}{Events: make(chan int)}
+
+	if _, err := Stringify(unmarshalable); err == nil {
+		t.Fatal("Stringify returned nil error for a channel field")
+	}
+	if got := MustStringify(unmarshalable); got != "" {
+		t.Fatalf("MustStringify = %q, want empty", got)
+	}
+
+	str, err := Stringify(map[string]int{"level": 1})
+	if err != nil {
+		t.Fatalf("Stringify: %v", err)
+	}
+	if str != `{"level":1}` {
+		t.Fatalf("Stringify = %s", str)
+	}
+}
+
+func TestUpdateUserData(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	updated := usersCache.UpdateUserData("uid_001", func(userData *UserData) {
+		userData.DisplayName = "emperor"
+	})
+	if !updated {
+		t.Fatal("UpdateUserData(uid_001) = false, want true")
+	}
+	if u, _ := usersCache.GetUserData("uid_001"); u.GetDisplayName() != "emperor" {
+		t.Fatalf("display name = %q, want emperor", u.GetDisplayName())
+	}
+
+	called := false
+	if usersCache.UpdateUserData("uid_404", func(*UserData) { called = true }) {
+		t.Fatal("UpdateUserData(uid_404) = true, want false")
+	}
+	if called {
+		t.Fatal("operation called for absent user")
+	}
+}
+
+func TestTryAddUserData(t *testing.T) {
+	tests := []struct {
+		name    string
+		ids     []string
+		wantErr bool
+		wantLen int
+	}{
+		{"below capacity", []string{"uid_005"}, false, 5},
+		{"at capacity", []string{"uid_005", "uid_006"}, false, 6},
+		{"above capacity", []string{"uid_005", "uid_006", "uid_007"}, true, 4},
+		{"replacing at capacity", []string{"uid_005", "uid_006", "uid_001"}, false, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usersCache := newLoadedCache(t)
+			usersCache.SetMaxUsers(6)
+			users := make([]*UserData, 0, len(tt.ids))
+			for _, userId := range tt.ids {
+				users = append(users, NewUserData(userId, "knight", 0, 0))
+			}
+			err := usersCache.TryAddUserData(users...)
+			if gotErr := errors.Is(err, ErrCacheFull); gotErr != tt.wantErr {
+				t.Fatalf("TryAddUserData error = %v, want ErrCacheFull %v", err, tt.wantErr)
+			}
+			if got := usersCache.Len(); got != tt.wantLen {
+				t.Fatalf("Len = %d, want %d", got, tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestGetOrCreateConcurrent(t *testing.T) {
+	usersCache := NewUsersCache()
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		created int
+		seen    = make(map[*UserData]struct{})
+	)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			userData, isNew := usersCache.GetOrCreate("uid_001", func() *UserData {
+				return NewUserData("uid_001", "king", 0, 0)
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if isNew {
+				created++
+			}
+			seen[userData] = struct{}{}
+		}()
+	}
+	wg.Wait()
+
+	if created != 1 {
+		t.Fatalf("created = %d, want 1", created)
+	}
+	if len(seen) != 1 {
+		t.Fatalf("goroutines observed %d distinct users, want 1", len(seen))
+	}
+	if got := usersCache.Len(); got != 1 {
+		t.Fatalf("Len = %d, want 1", got)
+	}
+}
+
+func TestPerformReadOperationCtxCancel(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	visited := 0
+	err := usersCache.PerformReadOperationCtx(ctx, func(userData *UserData) error {
+		visited++
+		if visited == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if visited != 2 {
+		t.Fatalf("visited = %d, want 2", visited)
+	}
+}
+
+func TestPerformReadOperationCtxOperationError(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	errStop := errors.New("stop")
+	visited := 0
+	err := usersCache.PerformReadOperationCtx(context.Background(), func(userData *UserData) error {
+		visited++
+		return errStop
+	})
+	if !errors.Is(err, errStop) {
+		t.Fatalf("err = %v, want errStop", err)
+	}
+	if visited != 1 {
+		t.Fatalf("visited = %d, want 1", visited)
+	}
+}
+
+func userIds(users []UserData) []string {
+	ids := make([]string, len(users))
+	for i := range users {
+		ids[i] = users[i].UserId
+	}
+	return ids
+}
+
+func TestTopByExperience(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	tests := []struct {
+		n    int
+		want []string
+	}{
+		{n: 2, want: []string{"uid_003", "uid_004"}},
+		{n: 10, want: []string{"uid_003", "uid_004", "uid_002", "uid_001"}},
+		{n: 0, want: []string{}},
+		{n: -1, want: []string{}},
+	}
+	for _, tt := range tests {
+		got := userIds(usersCache.TopByExperience(tt.n))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TopByExperience(%d) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestMapReduce(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	usersCache.AddUserData(NewUserData("uid_005", "knight", 2, 250))
+
+	levelCounts := MapReduce(usersCache,
+		excludeJohnFilter,
+		func(userData *UserData) int { return userData.GetGameLevel() },
+		func(levels []int) map[int]int {
+			counts := make(map[int]int)
+			for _, level := range levels {
+				counts[level]++
+			}
+			return counts
+		},
+	)
+	want := map[int]int{1: 3, 2: 1}
+	if !reflect.DeepEqual(levelCounts, want) {
+		t.Fatalf("level counts = %v, want %v", levelCounts, want)
+	}
+}
+
+func TestMapReduceUsersWithFilter(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	levelCounts := usersCache.MapReduceUsersWithFilter(excludeJohnFilter, userLevelMapper, levelCountReducer)
+	want := map[int]int{1: 3}
+	if !reflect.DeepEqual(levelCounts, want) {
+		t.Fatalf("level counts = %v, want %v", levelCounts, want)
+	}
+}
+
+func TestGetByDisplayName(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	usersCache.AddUserData(NewUserData("uid_005", "John", 3, 300))
+
+	johns, found := usersCache.GetByDisplayName("John")
+	if !found || len(johns) != 2 {
+		t.Fatalf("GetByDisplayName(John) = %d users, %v, want 2, true", len(johns), found)
+	}
+
+	if !usersCache.UpdateDisplayName("uid_005", "Johnny") {
+		t.Fatal("UpdateDisplayName(uid_005) = false, want true")
+	}
+	if johns, _ := usersCache.GetByDisplayName("John"); len(johns) != 1 {
+		t.Fatalf("GetByDisplayName(John) after rename = %d users, want 1", len(johns))
+	}
+	if johnnies, found := usersCache.GetByDisplayName("Johnny"); !found || johnnies[0].GetUserId() != "uid_005" {
+		t.Fatalf("GetByDisplayName(Johnny) = %v, %v", johnnies, found)
+	}
+
+	usersCache.RemoveUserData("uid_005")
+	if _, found := usersCache.GetByDisplayName("Johnny"); found {
+		t.Fatal("removed user still indexed")
+	}
+	if usersCache.UpdateDisplayName("uid_404", "ghost") {
+		t.Fatal("UpdateDisplayName(uid_404) = true, want false")
+	}
+}
+
+func TestWithUser(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	called := false
+	if usersCache.WithUser("uid_404", func(*UserData) { called = true }) {
+		t.Fatal("WithUser(uid_404) = true, want false")
+	}
+	if called {
+		t.Fatal("operation called for missing user")
+	}
+	if !usersCache.WithUser("uid_001", func(u *UserData) { u.SetExperience(199) }) {
+		t.Fatal("WithUser(uid_001) = false, want true")
+	}
+}
+
+func TestSetDisplayNameValidated(t *testing.T) {
+	tests := []struct {
+		name        string
+		displayName string
+		wantErr     bool
+	}{
+		{name: "empty", displayName: "", wantErr: true},
+		{name: "too long", displayName: strings.Repeat("ж", MaxDisplayNameLength+1), wantErr: true},
+		{name: "max length", displayName: strings.Repeat("ж", MaxDisplayNameLength)},
+		{name: "valid", displayName: "emperor"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userData := NewUserData("uid_001", "king", 1, 100)
+			err := userData.SetDisplayNameValidated(tt.displayName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			want := tt.displayName
+			if tt.wantErr {
+				want = "king"
+			}
+			if got := userData.GetDisplayName(); got != want {
+				t.Fatalf("display name = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestOnLevelUp(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 1, 150)
+	var calls [][2]int
+	userData.SetOnLevelUp(func(u *UserData, oldLevel, newLevel int) {
+		// Re-entering u must not deadlock
+		if u.GetGameLevel() != newLevel {
+			t.Errorf("GetGameLevel in callback = %d, want %d", u.GetGameLevel(), newLevel)
+		}
+		calls = append(calls, [2]int{oldLevel, newLevel})
+	})
+
+	userData.AddExperience(200)
+	userData.AddExperience(10)
+	want := [][2]int{{1, 3}}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("callback calls = %v, want %v", calls, want)
+	}
+}
+
+func TestBatchUpdate(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	updated := usersCache.BatchUpdate([]string{"uid_001", "uid_002", "uid_404", "uid_003"}, func(userData *UserData) {
+		userData.Experience += 50
+	})
+	if updated != 3 {
+		t.Fatalf("updated = %d, want 3", updated)
+	}
+	want := map[string]int64{"uid_001": 150, "uid_002": 160, "uid_003": 170, "uid_004": 120}
+	for userId, experience := range want {
+		u, _ := usersCache.GetUserData(userId)
+		if got := u.GetExperience(); got != experience {
+			t.Errorf("%s experience = %d, want %d", userId, got, experience)
+		}
+	}
+}
+
+func sortedIds(users []UserData) []string {
+	ids := userIds(users)
+	sort.Strings(ids)
+	return ids
+}
+
+func TestForEachStops(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	calls := 0
+	usersCache.ForEach(func(userData *UserData) bool {
+		calls++
+		return calls < 2
+	})
+	if calls != 2 {
+		t.Fatalf("calls = %d, want 2", calls)
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	if err := usersCache.Transfer("uid_003", "uid_001", 60); err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+	king, _ := usersCache.GetUserData("uid_001")
+	soldier, _ := usersCache.GetUserData("uid_003")
+	if king.GetExperience() != 160 || soldier.GetExperience() != 60 || soldier.GetGameLevel() != 0 {
+		t.Fatalf("after transfer king=%s soldier=%s", king.ToApi(), soldier.ToApi())
+	}
+
+	if err := usersCache.Transfer("uid_003", "uid_001", 61); err == nil {
+		t.Fatal("Transfer with insufficient experience returned nil error")
+	}
+	if err := usersCache.Transfer("uid_404", "uid_001", 1); err == nil {
+		t.Fatal("Transfer from missing user returned nil error")
+	}
+	if err := usersCache.Transfer("uid_001", "uid_404", 1); err == nil {
+		t.Fatal("Transfer to missing user returned nil error")
+	}
+}
+
+func TestTransferClampsRecipient(t *testing.T) {
+	usersCache := NewUsersCache()
+	usersCache.AddUserData(NewUserData("uid_a", "a", 0, 100), NewUserData("uid_b", "b", 0, math.MaxInt64-10))
+	if err := usersCache.Transfer("uid_a", "uid_b", 50); err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+	to, _ := usersCache.GetUserData("uid_b")
+	if got := to.GetExperience(); got != math.MaxInt64 {
+		t.Fatalf("recipient experience = %d, want math.MaxInt64", got)
+	}
+}
+
+func TestTransferOppositeDirectionsNoDeadlock(t *testing.T) {
+	usersCache := NewUsersCache()
+	usersCache.AddUserData(NewUserData("uid_a", "a", 10, 1000), NewUserData("uid_b", "b", 10, 1000))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_ = usersCache.Transfer("uid_a", "uid_b", 1)
+		}()
+		go func() {
+			defer wg.Done()
+			_ = usersCache.Transfer("uid_b", "uid_a", 1)
+		}()
+	}
+	wg.Wait()
+
+	a, _ := usersCache.GetUserData("uid_a")
+	b, _ := usersCache.GetUserData("uid_b")
+	if total := a.GetExperience() + b.GetExperience(); total != 2000 {
+		t.Fatalf("total experience = %d, want 2000", total)
+	}
+}
+
+type failingUserSource struct {
+	err error
+}
+
+func (s failingUserSource) LoadAll() ([]*UserData, error) {
+	return nil, s.err
+}
+
+// flakyUserSource fails its first failures calls, then serves MockUserSource
+type flakyUserSource struct {
+	failures int
+	calls    int
+}
+
+func (s *flakyUserSource) LoadAll() ([]*UserData, error) {
+	s.calls++
+	if s.calls <= s.failures {
+		return nil, errors.New("transient failure")
+	}
+	return MockUserSource{}.LoadAll()
+}
+
+func TestLoadWithRetry(t *testing.T) {
+	src := &flakyUserSource{failures: 2}
+	usersCache := NewUsersCache()
+	if err := LoadWithRetry(usersCache, src, 3, time.Millisecond); err != nil {
+		t.Fatalf("LoadWithRetry: %v", err)
+	}
+	if src.calls != 3 || usersCache.Len() != 4 {
+		t.Fatalf("calls = %d, Len = %d, want 3 and 4", src.calls, usersCache.Len())
+	}
+
+	src = &flakyUserSource{failures: 3}
+	if err := LoadWithRetry(NewUsersCache(), src, 3, time.Millisecond); err == nil {
+		t.Fatal("LoadWithRetry returned nil error after exhausting attempts")
+	}
+	if src.calls != 3 {
+		t.Fatalf("calls = %d, want 3", src.calls)
+	}
+}
+
+func TestLoadUsersDataFromDBError(t *testing.T) {
+	errDown := errors.New("database is down")
+	usersCache := NewUsersCache()
+	err := LoadUsersDataFromDB(usersCache, failingUserSource{err: errDown})
+	if !errors.Is(err, errDown) {
+		t.Fatalf("err = %v, want %v", err, errDown)
+	}
+	if got := usersCache.Len(); got != 0 {
+		t.Fatalf("Len = %d, want 0", got)
+	}
+}
+
+func TestCloneIsolation(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	clone := usersCache.Clone()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			usersCache.WithUser("uid_001", func(u *UserData) { u.AddExperience(1) })
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			clone.WithUser("uid_001", func(u *UserData) { u.AddExperience(-1) })
+		}
+		clone.RemoveUserData("uid_002")
+	}()
+	wg.Wait()
+
+	original, _ := usersCache.GetUserData("uid_001")
+	cloned, _ := clone.GetUserData("uid_001")
+	if original == cloned {
+		t.Fatal("clone shares user pointers with the original")
+	}
+	if got := original.GetExperience(); got != 200 {
+		t.Fatalf("original experience = %d, want 200", got)
+	}
+	if got := cloned.GetExperience(); got != 0 {
+		t.Fatalf("clone experience = %d, want 0", got)
+	}
+	if usersCache.Len() != 4 || clone.Len() != 3 {
+		t.Fatalf("Len original = %d clone = %d, want 4 and 3", usersCache.Len(), clone.Len())
+	}
+}
+
+func TestAddExperienceClampsOnOverflow(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 0, math.MaxInt64-10)
+	if _, clamped := userData.AddExperience(5); clamped {
+		t.Fatal("clamped = true for an in-range add")
+	}
+	if _, clamped := userData.AddExperience(math.MaxInt64); !clamped {
+		t.Fatal("clamped = false for an overflowing add")
+	}
+	if got := userData.GetExperience(); got != math.MaxInt64 {
+		t.Fatalf("experience = %d, want MaxInt64", got)
+	}
+	if got := userData.GetGameLevel(); got < 0 {
+		t.Fatalf("level = %d, want non-negative", got)
+	}
+}
+
+func TestUserView(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 1, 100)
+	view := userData.View()
+
+	viewType := reflect.TypeOf((*UserView)(nil)).Elem()
+	for i := 0; i < viewType.NumMethod(); i++ {
+		if name := viewType.Method(i).Name; strings.HasPrefix(name, "Set") {
+			t.Fatalf("UserView exposes %s", name)
+		}
+	}
+
+	userData.SetExperience(250)
+	if view.GetExperience() != 250 || view.GetGameLevel() != 2 {
+		t.Fatalf("view reads experience %d level %d, want 250 and 2", view.GetExperience(), view.GetGameLevel())
+	}
+	if view.GetUserId() != "uid_001" || view.GetDisplayName() != "king" {
+		t.Fatalf("view reads %q %q", view.GetUserId(), view.GetDisplayName())
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "one field",
+			patch: `{"display_name":"emperor"}`,
+			want:  `{"uid":"uid_001","display_name":"emperor","game_level":1,"experience":100}`,
+		},
+		{
+			name:  "multiple fields",
+			patch: `{"display_name":"emperor","experience":420}`,
+			want:  `{"uid":"uid_001","display_name":"emperor","game_level":4,"experience":420}`,
+		},
+		{
+			name:  "unknown field",
+			patch: `{"favourite_color":"red","game_level":3}`,
+			want:  `{"uid":"uid_001","display_name":"king","game_level":3,"experience":100}`,
+		},
+		{
+			name:    "wrong type",
+			patch:   `{"display_name":"emperor","experience":"lots"}`,
+			want:    `{"uid":"uid_001","display_name":"king","game_level":1,"experience":100}`,
+			wantErr: true,
+		},
+		{
+			name:    "read-only uid",
+			patch:   `{"uid":"uid_999"}`,
+			want:    `{"uid":"uid_001","display_name":"king","game_level":1,"experience":100}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userData := NewUserData("uid_001", "king", 1, 100)
+			err := ApplyPatch(userData, []byte(tt.patch))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := toApiFields(userData); got != tt.want {
+				t.Fatalf("user = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyPatchWithoutFieldsKeepsVersion(t *testing.T) {
+	for _, patch := range []string{`{}`, `{"favourite_color":"red"}`} {
+		userData := NewUserData("uid_001", "king", 1, 100)
+		version, updatedAt := userData.Version(), userData.GetUpdatedAt()
+		if err := ApplyPatch(userData, []byte(patch)); err != nil {
+			t.Fatalf("ApplyPatch(%s): %v", patch, err)
+		}
+		if userData.Version() != version || !userData.GetUpdatedAt().Equal(updatedAt) {
+			t.Fatalf("ApplyPatch(%s) marked the user modified", patch)
+		}
+	}
+}
+
+func TestCacheMetrics(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	for _, userId := range []string{"uid_001", "uid_002", "uid_404", "uid_001", "uid_405"} {
+		usersCache.GetUserData(userId)
+	}
+	hits, misses := usersCache.CacheMetrics()
+	if hits != 3 || misses != 2 {
+		t.Fatalf("hits = %d, misses = %d, want 3 and 2", hits, misses)
+	}
+}
+
+func TestAddUserDataUnique(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	added, err := usersCache.AddUserDataUnique(
+		NewUserData("uid_005", "knight", 0, 0),
+		NewUserData("uid_006", "bishop", 0, 0),
+	)
+	if err != nil || added != 2 {
+		t.Fatalf("AddUserDataUnique = %d, %v, want 2, nil", added, err)
+	}
+	if got := usersCache.Len(); got != 6 {
+		t.Fatalf("Len = %d, want 6", got)
+	}
+}
+
+func TestAddUserDataUniqueConflict(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	added, err := usersCache.AddUserDataUnique(
+		NewUserData("uid_005", "knight", 0, 0),
+		NewUserData("uid_002", "impostor", 0, 0),
+	)
+	if err == nil || added != 0 {
+		t.Fatalf("AddUserDataUnique = %d, %v, want 0 and an error", added, err)
+	}
+	if !strings.Contains(err.Error(), "uid_002") {
+		t.Fatalf("error %q does not name uid_002", err)
+	}
+	if got := usersCache.Len(); got != 4 {
+		t.Fatalf("Len = %d, want 4", got)
+	}
+	if queen, _ := usersCache.GetUserData("uid_002"); queen.GetDisplayName() != "queen" {
+		t.Fatalf("uid_002 was overwritten with %q", queen.GetDisplayName())
+	}
+}
+
+func TestReset(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 0, 0)
+	userData.SetExperience(750)
+	userData.SetInternalData("flagged")
+
+	userData.Reset()
+	got := userData.CopyValue()
+	if got.UserId != "uid_001" || got.DisplayName != "king" ||
+		got.GameLevel != 0 || got.Experience != 0 || got.UserInternalData != "" {
+		t.Fatalf("after Reset = %s, internal data %q", userData.ToApi(), got.UserInternalData)
+	}
+}
+
+func TestInternalDataConcurrentWithToApi(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 1, 100)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			userData.SetInternalData(strconv.Itoa(i))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			userData.ToApi()
+			userData.GetInternalData()
+		}
+	}()
+	wg.Wait()
+	if got := userData.GetInternalData(); got != "999" {
+		t.Fatalf("internal data = %q, want 999", got)
+	}
+}
+
+func TestCompareAndSetExperience(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 1, 100)
+	if !userData.CompareAndSetExperience(100, 250) {
+		t.Fatal("matching CAS failed")
+	}
+	if userData.GetExperience() != 250 || userData.GetGameLevel() != 2 {
+		t.Fatalf("after CAS = %s", userData.ToApi())
+	}
+	if userData.CompareAndSetExperience(100, 999) {
+		t.Fatal("stale CAS succeeded")
+	}
+	if got := userData.GetExperience(); got != 250 {
+		t.Fatalf("experience after stale CAS = %d, want 250", got)
+	}
+}
+
+func TestGetMultiple(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	found, missing := usersCache.GetMultiple("uid_001", "uid_404", "uid_003", "uid_405")
+	if len(found) != 2 || found["uid_001"] == nil || found["uid_003"] == nil {
+		t.Fatalf("found = %v", found)
+	}
+	if want := []string{"uid_404", "uid_405"}; !reflect.DeepEqual(missing, want) {
+		t.Fatalf("missing = %v, want %v", missing, want)
+	}
+}
+
+func TestDecayExperience(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 0, 0)
+	userData.SetExperience(450)
+	if err := userData.DecayExperience(0.5); err != nil {
+		t.Fatalf("DecayExperience: %v", err)
+	}
+	if userData.GetExperience() != 225 || userData.GetGameLevel() != 2 {
+		t.Fatalf("after decay = %s", userData.ToApi())
+	}
+	for _, factor := range []float64{0, -0.5, 1.5, math.NaN()} {
+		if err := userData.DecayExperience(factor); err == nil {
+			t.Errorf("DecayExperience(%v) returned nil error", factor)
+		}
+	}
+	if got := userData.GetExperience(); got != 225 {
+		t.Fatalf("experience after rejected decays = %d, want 225", got)
+	}
+}
+
+func TestDecayAll(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	if err := usersCache.DecayAll(0.5); err != nil {
+		t.Fatalf("DecayAll: %v", err)
+	}
+	stats := usersCache.Stats()
+	if stats.TotalExperience != 225 || stats.MaxLevel != 0 {
+		t.Fatalf("after DecayAll stats = %+v", stats)
+	}
+	if err := usersCache.DecayAll(2); err == nil {
+		t.Fatal("DecayAll(2) returned nil error")
+	}
+}
+
+func TestDecayAllNotifiesAfterUnlock(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	calls := watchLevelCallbacks(t, usersCache)
+	if err := usersCache.DecayAll(0.5); err != nil {
+		t.Fatalf("DecayAll: %v", err)
+	}
+	// all four users drop from level 1 to 0
+	if *calls != 4 {
+		t.Fatalf("level callbacks ran %d times, want 4", *calls)
+	}
+}
+
+func TestUpdateDataResult(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 1, 100)
+	experience := UpdateDataResult(userData, func(u *UserData) int64 {
+		u.Experience += 42
+		return u.Experience
+	})
+	if experience != 142 {
+		t.Fatalf("result = %d, want 142", experience)
+	}
+}
+
+func TestPromoteLevel(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 1, 150)
+	if level := userData.PromoteLevel(2); level != 3 {
+		t.Fatalf("level = %d, want 3", level)
+	}
+	if got := userData.GetExperience(); got != ExperienceFloor(3) || got != 300 {
+		t.Fatalf("experience = %d, want 300", got)
+	}
+	if level := userData.PromoteLevel(-10); level != 0 {
+		t.Fatalf("level after demotion = %d, want 0", level)
+	}
+
+	userData = NewUserData("uid_002", "queen", 2, 250)
+	if level := userData.PromoteLevel(-1); level != 1 {
+		t.Fatalf("level after demotion = %d, want 1", level)
+	}
+	if got := userData.GetExperience(); got != ExperienceFloor(2)-1 || LevelCurve(got) != 1 {
+		t.Fatalf("experience after demotion = %d, want %d at level 1", got, ExperienceFloor(2)-1)
+	}
+
+	// the stored level is below the curve level, promoting must keep the experience
+	userData = NewUserData("uid_003", "soldier", 1, 500)
+	if level := userData.PromoteLevel(1); level != 2 {
+		t.Fatalf("level after promotion = %d, want 2", level)
+	}
+	if got := userData.GetExperience(); got != 500 {
+		t.Fatalf("experience after promotion = %d, want 500", got)
+	}
+}
+
+func TestExperienceFloorCustomCurve(t *testing.T) {
+	defer func(curve func(int64) int) { LevelCurve = curve }(LevelCurve)
+	LevelCurve = func(experience int64) int {
+		return int(math.Sqrt(float64(experience / 50)))
+	}
+	// level 4 needs experience/50 >= 16
+	if got := ExperienceFloor(4); got != 800 {
+		t.Fatalf("ExperienceFloor(4) = %d, want 800", got)
+	}
+}
+
+func TestAddExperienceWhere(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	usersCache.AddUserData(NewUserData("uid_005", "knight", 12, 1250))
+	belowTen := func(userData *UserData) bool { return userData.GetGameLevel() < 10 }
+
+	if affected := usersCache.AddExperienceWhere(belowTen, 100); affected != 4 {
+		t.Fatalf("affected = %d, want 4", affected)
+	}
+	soldier, _ := usersCache.GetUserData("uid_003")
+	if soldier.GetExperience() != 220 || soldier.GetGameLevel() != 2 {
+		t.Fatalf("uid_003 = %s", soldier.ToApi())
+	}
+	knight, _ := usersCache.GetUserData("uid_005")
+	if got := knight.GetExperience(); got != 1250 {
+		t.Fatalf("uid_005 experience = %d, want 1250", got)
+	}
+}
+
+func TestAddExperienceWhereNotifiesAfterUnlock(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	calls := watchLevelCallbacks(t, usersCache)
+	if affected := usersCache.AddExperienceWhere(func(*UserData) bool { return true }, 1000); affected != 4 {
+		t.Fatalf("affected = %d, want 4", affected)
+	}
+	// every user levels up, firing both OnLevelUp and OnLevelChange
+	if *calls != 8 {
+		t.Fatalf("level callbacks ran %d times, want 8", *calls)
+	}
+}
+
+func TestMergeFrom(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	other := NewUsersCache()
+	other.AddUserData(
+		NewUserData("uid_001", "king", 3, 300),
+		NewUserData("uid_002", "queen", 0, 10),
+		NewUserData("uid_005", "knight", 0, 0),
+	)
+	keepHigherExperience := func(existing, incoming *UserData) *UserData {
+		if incoming.GetExperience() > existing.GetExperience() {
+			return incoming
+		}
+		return existing
+	}
+	usersCache.MergeFrom(other, keepHigherExperience)
+
+	if got := usersCache.Len(); got != 5 {
+		t.Fatalf("Len = %d, want 5", got)
+	}
+	want := map[string]int64{"uid_001": 300, "uid_002": 110, "uid_005": 0}
+	for userId, experience := range want {
+		u, found := usersCache.GetUserData(userId)
+		if !found || u.GetExperience() != experience {
+			t.Errorf("%s = %v, %v, want experience %d", userId, u, found, experience)
+		}
+	}
+}
+
+func TestMergeFromBothWaysNoDeadlock(t *testing.T) {
+	a, b := newLoadedCache(t), NewUsersCache()
+	b.AddUserData(NewUserData("uid_005", "knight", 0, 0))
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			a.MergeFrom(b, nil)
+		}()
+		go func() {
+			defer wg.Done()
+			b.MergeFrom(a, nil)
+		}()
+	}
+	wg.Wait()
+	if a.Len() != 5 || b.Len() != 5 {
+		t.Fatalf("Len a = %d b = %d, want 5 and 5", a.Len(), b.Len())
+	}
+}
+
+func TestLockUsers(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	unlock, users := usersCache.LockUsers("uid_003", "uid_404", "uid_001", "uid_003")
+	if len(users) != 2 || users[0].UserId != "uid_001" || users[1].UserId != "uid_003" {
+		t.Fatalf("locked %d users", len(users))
+	}
+	unlock()
+	// Locks must be released
+	if got := users[0].GetExperience(); got != 100 {
+		t.Fatalf("experience = %d, want 100", got)
+	}
+}
+
+func TestLockUsersOverlappingNoDeadlock(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	sets := [][]string{
+		{"uid_001", "uid_002", "uid_003"},
+		{"uid_003", "uid_002"},
+		{"uid_004", "uid_001"},
+		{"uid_002", "uid_004", "uid_001"},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		for _, set := range sets {
+			wg.Add(1)
+			go func(userIds []string) {
+				defer wg.Done()
+				unlock, users := usersCache.LockUsers(userIds...)
+				for _, userData := range users {
+					userData.Experience++
+				}
+				unlock()
+			}(set)
+		}
+	}
+	wg.Wait()
+
+	stats := usersCache.Stats()
+	if want := int64(450 + 100*10); stats.TotalExperience != want {
+		t.Fatalf("total experience = %d, want %d", stats.TotalExperience, want)
+	}
+}
+
+func TestOnLevelChange(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 0, 0)
+	userData.SetExperience(450)
+
+	var changes [][2]int
+	userData.SetOnLevelChange(func(u *UserData, oldLevel, newLevel int) {
+		changes = append(changes, [2]int{oldLevel, newLevel})
+	})
+	levelUps := 0
+	userData.SetOnLevelUp(func(*UserData, int, int) { levelUps++ })
+
+	if err := userData.DecayExperience(0.5); err != nil {
+		t.Fatalf("DecayExperience: %v", err)
+	}
+	userData.AddExperience(1) // no level change
+	userData.PromoteLevel(1)
+	userData.SetExperience(0)
+
+	want := [][2]int{{4, 2}, {2, 3}, {3, 0}}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("changes = %v, want %v", changes, want)
+	}
+	if levelUps != 0 {
+		t.Fatalf("level-up callback fired %d times, want 0", levelUps)
+	}
+}
+
+func TestOnLevelChangeFromTransferAndPatch(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	king, _ := usersCache.GetUserData("uid_001")
+	soldier, _ := usersCache.GetUserData("uid_003")
+
+	var changes []string
+	record := func(u *UserData, oldLevel, newLevel int) {
+		changes = append(changes, fmt.Sprintf("%s:%d->%d", u.GetUserId(), oldLevel, newLevel))
+	}
+	king.SetOnLevelChange(record)
+	soldier.SetOnLevelChange(record)
+
+	if err := usersCache.Transfer("uid_001", "uid_003", 100); err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+	if err := ApplyPatch(king, []byte(`{"experience":420}`)); err != nil {
+		t.Fatalf("ApplyPatch: %v", err)
+	}
+	if err := ApplyPatch(king, []byte(`{"display_name":"emperor"}`)); err != nil {
+		t.Fatalf("ApplyPatch: %v", err)
+	}
+
+	want := []string{"uid_001:1->0", "uid_003:1->2", "uid_001:0->4"}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("changes = %v, want %v", changes, want)
+	}
+}
+
+func TestRenameUser(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	if err := usersCache.RenameUser("uid_003", "general"); err != nil {
+		t.Fatalf("RenameUser: %v", err)
+	}
+	generals, found := usersCache.GetByDisplayName("general")
+	if !found || len(generals) != 1 || generals[0].GetUserId() != "uid_003" {
+		t.Fatalf("GetByDisplayName(general) = %v, %v", generals, found)
+	}
+	if _, found := usersCache.GetByDisplayName("soldier"); found {
+		t.Fatal("old name still indexed")
+	}
+	if err := usersCache.RenameUser("uid_404", "ghost"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("err = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	users := usersCache.Drain()
+	if len(users) != 4 {
+		t.Fatalf("drained %d users, want 4", len(users))
+	}
+	for i, userData := range users {
+		if userData == nil {
+			t.Fatalf("users[%d] is nil", i)
+		}
+	}
+	if got := usersCache.Len(); got != 0 {
+		t.Fatalf("Len after Drain = %d, want 0", got)
+	}
+}
+
+func TestSnapshotForEachConcurrentWithWriters(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			userId := "uid_" + strconv.Itoa(100+i%50)
+			usersCache.AddUserData(NewUserData(userId, "knight", 0, int64(i)))
+			usersCache.RemoveUserData(userId)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			usersCache.SnapshotForEach(func(userData *UserData) {
+				if userData.UserId == "" {
+					t.Error("snapshot copy has empty UserId")
+				}
+				userData.Experience++
+			})
+		}
+	}()
+	wg.Wait()
+
+	userData, _ := usersCache.GetUserData("uid_001")
+	if got := userData.GetExperience(); got != 100 {
+		t.Fatalf("experience = %d, want 100 untouched by snapshot copies", got)
+	}
+}
+
+func TestSwapDisplayNames(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_ = usersCache.SwapDisplayNames("uid_001", "uid_002")
+		}()
+		go func() {
+			defer wg.Done()
+			_ = usersCache.SwapDisplayNames("uid_002", "uid_001")
+		}()
+	}
+	wg.Wait()
+
+	userA, _ := usersCache.GetUserData("uid_001")
+	userB, _ := usersCache.GetUserData("uid_002")
+	if got, want := []string{userA.GetDisplayName(), userB.GetDisplayName()}, []string{"king", "queen"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("names after an even number of swaps = %v, want %v", got, want)
+	}
+
+	if err := usersCache.SwapDisplayNames("uid_001", "uid_002"); err != nil {
+		t.Fatalf("SwapDisplayNames: %v", err)
+	}
+	for name, want := range map[string]string{"king": "uid_002", "queen": "uid_001"} {
+		users, found := usersCache.GetByDisplayName(name)
+		if !found || len(users) != 1 || users[0].GetUserId() != want {
+			t.Fatalf("GetByDisplayName(%q) found %v with %d users, want only %s", name, found, len(users), want)
+		}
+	}
+
+	if err := usersCache.SwapDisplayNames("uid_001", "uid_404"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("SwapDisplayNames with missing user error = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 1, 100)
+	tests := []struct {
+		name  string
+		other *UserData
+		want  bool
+	}{
+		{"identical fields", NewUserData("uid_001", "king", 1, 100), true},
+		{"same pointer", userData, true},
+		{"different experience", NewUserData("uid_001", "king", 1, 101), false},
+		{"different name", NewUserData("uid_001", "queen", 1, 100), false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		if got := userData.Equal(tt.other); got != tt.want {
+			t.Fatalf("%s: Equal = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEqualConcurrentWithTransfer(t *testing.T) {
+	usersCache := NewUsersCache()
+	usersCache.AddUserData(NewUserData("uid_a", "a", 0, 1000), NewUserData("uid_b", "b", 0, 1000))
+	a, _ := usersCache.GetUserData("uid_a")
+	b, _ := usersCache.GetUserData("uid_b")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				a.Equal(b)
+				b.Equal(a)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				usersCache.Transfer("uid_a", "uid_b", 1)
+				usersCache.Transfer("uid_b", "uid_a", 1)
+			}
+		}()
+		wg.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Equal deadlocked with Transfer")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	usersCache := NewUsersCache()
+	for i := 0; i < 10000; i++ {
+		usersCache.AddUserData(NewUserData("uid_"+strconv.Itoa(i), "knight", 0, int64(i)))
+	}
+	for i := 100; i < 10000; i++ {
+		usersCache.RemoveUserData("uid_" + strconv.Itoa(i))
+	}
+	usersCache.Compact()
+
+	if got := usersCache.Len(); got != 100 {
+		t.Fatalf("Len after Compact = %d, want 100", got)
+	}
+	userData, found := usersCache.GetUserData("uid_42")
+	if !found || userData.GetExperience() != 42 {
+		t.Fatalf("uid_42 after Compact found %v", found)
+	}
+	if users, found := usersCache.GetByDisplayName("knight"); !found || len(users) != 100 {
+		t.Fatalf("GetByDisplayName after Compact found %d users, want 100", len(users))
+	}
+}
+
+func TestPerformReadOperationE(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	errThird := errors.New("third user")
+	calls := 0
+	err := usersCache.PerformReadOperationE(func(userData *UserData) error {
+		calls++
+		if calls == 3 {
+			return errThird
+		}
+		return nil
+	})
+	if !errors.Is(err, errThird) {
+		t.Fatalf("PerformReadOperationE error = %v, want %v", err, errThird)
+	}
+	if calls != 3 {
+		t.Fatalf("operation ran %d times, want 3", calls)
+	}
+
+	if err := usersCache.PerformReadOperationE(func(*UserData) error { return nil }); err != nil {
+		t.Fatalf("PerformReadOperationE without failures = %v, want nil", err)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 1, 100)
+	version := userData.Version()
+
+	userData.GetExperience()
+	userData.GetSnapshotFields()
+	userData.ToApi()
+	if got := userData.Version(); got != version {
+		t.Fatalf("version after reads = %d, want %d", got, version)
+	}
+
+	mutations := []func(){
+		func() { userData.SetDisplayName("queen") },
+		func() { userData.SetExperience(150) },
+		func() { userData.AddExperience(10) },
+		func() { userData.UpdateData(func(u *UserData) { u.GameLevel = 3 }) },
+		func() { userData.CompareAndSetExperience(160, 170) },
+	}
+	for i, mutate := range mutations {
+		mutate()
+		version++
+		if got := userData.Version(); got != version {
+			t.Fatalf("version after mutation %d = %d, want %d", i, got, version)
+		}
+	}
+
+	userData.CompareAndSetExperience(0, 1)
+	if got := userData.Version(); got != version {
+		t.Fatalf("version after failed CompareAndSetExperience = %d, want %d", got, version)
+	}
+}
+
+func TestGeneration(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	generation := usersCache.Generation()
+
+	usersCache.UpdateUserData("uid_001", func(u *UserData) { u.Experience = 500 })
+	_, _ = usersCache.CacheAddExperience("uid_002", 10)
+	if got := usersCache.Generation(); got != generation {
+		t.Fatalf("generation after field updates = %d, want %d", got, generation)
+	}
+
+	steps := []struct {
+		name   string
+		mutate func()
+	}{
+		{"add", func() { usersCache.AddUserData(NewUserData("uid_005", "knight", 0, 0)) }},
+		{"remove", func() { usersCache.RemoveUserData("uid_005") }},
+		{"clear", usersCache.Clear},
+	}
+	for _, step := range steps {
+		step.mutate()
+		got := usersCache.Generation()
+		if got == generation {
+			t.Fatalf("generation unchanged after %s", step.name)
+		}
+		generation = got
+	}
+
+	usersCache.RemoveUserData("uid_404")
+	if got := usersCache.Generation(); got != generation {
+		t.Fatalf("generation after removing a missing user = %d, want %d", got, generation)
+	}
+}
+
+func TestApplyIfNewer(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	stored, _ := usersCache.GetUserData("uid_001")
+	storedAt := stored.GetUpdatedAt()
+
+	older := NewUserData("uid_001", "old king", 1, 50)
+	older.UpdatedAt = storedAt.Add(-time.Minute)
+	if usersCache.ApplyIfNewer(older) {
+		t.Fatal("ApplyIfNewer applied an older update")
+	}
+	if got, _ := usersCache.GetUserData("uid_001"); got != stored {
+		t.Fatal("older update replaced the stored user")
+	}
+
+	newer := NewUserData("uid_001", "new king", 2, 250)
+	newer.UpdatedAt = storedAt.Add(time.Minute)
+	if !usersCache.ApplyIfNewer(newer) {
+		t.Fatal("ApplyIfNewer rejected a newer update")
+	}
+	if got, _ := usersCache.GetUserData("uid_001"); got != newer {
+		t.Fatal("newer update was not stored")
+	}
+	if users, found := usersCache.GetByDisplayName("new king"); !found || len(users) != 1 {
+		t.Fatal("display name index not updated")
+	}
+
+	if !usersCache.ApplyIfNewer(NewUserData("uid_005", "knight", 0, 0)) {
+		t.Fatal("ApplyIfNewer rejected a user that wasn't cached")
+	}
+}
+
+func TestApplyIfNewerParsedUser(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	stored, _ := usersCache.GetUserData("uid_001")
+
+	// a user serialized on another node keeps its UpdatedAt through ToApi
+	remoteCopy := stored.CopyValue()
+	remote := &remoteCopy
+	remote.SetDisplayName("remote king")
+	incoming, err := ParseUserData(remote.ToApi())
+	if err != nil {
+		t.Fatalf("ParseUserData: %v", err)
+	}
+	if !usersCache.ApplyIfNewer(incoming) {
+		t.Fatal("ApplyIfNewer rejected a parsed newer user")
+	}
+	if got, _ := usersCache.GetUserData("uid_001"); got.GetDisplayName() != "remote king" {
+		t.Fatalf("display name = %q, want remote king", got.GetDisplayName())
+	}
+
+	stale, err := ParseUserData(stored.ToApi())
+	if err != nil {
+		t.Fatalf("ParseUserData: %v", err)
+	}
+	if usersCache.ApplyIfNewer(stale) {
+		t.Fatal("ApplyIfNewer applied a parsed older user")
+	}
+}
+
+func TestUpdatedAtAdvancesOnMutation(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 1, 100)
+	userData.UpdatedAt = time.Time{}
+	userData.GetExperience()
+	if !userData.GetUpdatedAt().IsZero() {
+		t.Fatal("read changed UpdatedAt")
+	}
+	userData.AddExperience(10)
+	if userData.GetUpdatedAt().IsZero() {
+		t.Fatal("AddExperience did not set UpdatedAt")
+	}
+}
+
+func TestNewUserDataValidated(t *testing.T) {
+	userData, err := NewUserDataValidated("uid_001", "king", 7, 150)
+	if err != nil {
+		t.Fatalf("NewUserDataValidated: %v", err)
+	}
+	if got := userData.GetGameLevel(); got != LevelCurve(150) {
+		t.Fatalf("level = %d, want %d derived from experience", got, LevelCurve(150))
+	}
+
+	tests := []struct {
+		name       string
+		userId     string
+		gameLevel  int
+		experience int64
+	}{
+		{"empty user id", "", 1, 100},
+		{"negative level", "uid_001", -1, 100},
+		{"negative experience", "uid_001", 1, -100},
+	}
+	for _, tt := range tests {
+		if userData, err := NewUserDataValidated(tt.userId, "king", tt.gameLevel, tt.experience); err == nil || userData != nil {
+			t.Fatalf("%s: NewUserDataValidated = (%v, %v), want an error", tt.name, userData, err)
+		}
+	}
+}
+
+func TestUpdateIf(t *testing.T) {
+	belowLevel5 := func(u *UserData) bool { return u.GameLevel < 5 }
+	grant := func(u *UserData) {
+		u.Experience += 100
+		u.GameLevel = LevelCurve(u.Experience)
+	}
+
+	userData := NewUserData("uid_001", "king", 3, 300)
+	if !userData.UpdateIf(belowLevel5, grant) {
+		t.Fatal("UpdateIf below level 5 did not run operation")
+	}
+	if got := userData.GetExperience(); got != 400 {
+		t.Fatalf("experience = %d, want 400", got)
+	}
+
+	userData = NewUserData("uid_002", "queen", 5, 500)
+	version := userData.Version()
+	if userData.UpdateIf(belowLevel5, grant) {
+		t.Fatal("UpdateIf at level 5 ran operation")
+	}
+	if userData.GetExperience() != 500 || userData.Version() != version {
+		t.Fatalf("user changed when pred was false: %s", userData.ToApi())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    *UserData
+		wantErr bool
+	}{
+		{"valid", NewUserData("uid_001", "king", 1, 100), false},
+		{"empty user id", NewUserData("", "king", 1, 100), true},
+		{"negative level", NewUserData("uid_001", "king", -1, 100), true},
+		{"negative experience", NewUserData("uid_001", "king", 1, -1), true},
+	}
+	for _, tt := range tests {
+		if err := tt.user.Validate(); (err != nil) != tt.wantErr {
+			t.Fatalf("%s: Validate = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSetUserId(t *testing.T) {
+	usersCache := NewUsersCache()
+	userData := NewUserData("uid_001", "king", 1, 100)
+	usersCache.AddUserData(userData)
+	if err := usersCache.SetUserId("uid_001", "uid_101"); err != nil {
+		t.Fatalf("SetUserId: %v", err)
+	}
+
+	if _, found := usersCache.GetUserData("uid_001"); found {
+		t.Fatal("old id uid_001 is still cached")
+	}
+	if got, found := usersCache.GetUserData("uid_101"); !found || got != userData {
+		t.Fatalf("GetUserData(uid_101) = %p, %v, want the original user %p", got, found, userData)
+	}
+	if got := userData.GetUserId(); got != "uid_101" {
+		t.Fatalf("GetUserId = %q, want uid_101", got)
+	}
+	if users, found := usersCache.GetByDisplayName("king"); !found || len(users) != 1 || users[0].GetUserId() != "uid_101" {
+		t.Fatal("display name index was not moved to uid_101")
+	}
+	if users := usersCache.UsersAtLevel(1); len(users) != 1 || users[0].UserId != "uid_101" {
+		t.Fatal("level index was not moved to uid_101")
+	}
+}
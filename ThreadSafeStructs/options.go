@@ -0,0 +1,95 @@
+package main
+
+import "time"
+
+type cacheOptions struct {
+	initialCapacity int
+	maxUsers        int
+	lru             bool
+	copyOnWrite     bool
+	janitorInterval time.Duration
+	janitorMaxIdle  time.Duration
+	wal             Appender
+	logger          Logger
+}
+
+// CacheOption configures NewUsersCacheWithOptions
+type CacheOption func(options *cacheOptions)
+
+// WithInitialCapacity presizes the user map, also used when the cache is cleared
+func WithInitialCapacity(capacity int) CacheOption {
+	return func(options *cacheOptions) {
+		options.initialCapacity = capacity
+	}
+}
+
+// WithMaxUsers caps the cache size for TryAddUserData, see SetMaxUsers
+func WithMaxUsers(maxUsers int) CacheOption {
+	return func(options *cacheOptions) {
+		options.maxUsers = maxUsers
+	}
+}
+
+// WithLRU caps the cache at maxUsers and evicts the least recently used users instead
+// of refusing inserts. GetUserData, WithUser, UpdateUserData, UpdateDisplayName and
+// Transfer mark a user as used, as does inserting it
+func WithLRU(maxUsers int) CacheOption {
+	return func(options *cacheOptions) {
+		options.maxUsers = maxUsers
+		options.lru = true
+	}
+}
+
+// WithCopyOnWriteSnapshot makes every method that adds, removes or evicts users rebuild
+// the map returned by Snapshot, so readers load it without locking. Every such write
+// then copies the whole cache, use it for read-heavy caches only
+func WithCopyOnWriteSnapshot() CacheOption {
+	return func(options *cacheOptions) {
+		options.copyOnWrite = true
+	}
+}
+
+// WithJanitor starts the idle eviction janitor, see StartJanitor
+func WithJanitor(interval time.Duration, maxIdle time.Duration) CacheOption {
+	return func(options *cacheOptions) {
+		options.janitorInterval = interval
+		options.janitorMaxIdle = maxIdle
+	}
+}
+
+// WithWAL journals mutations to appender, see SetWAL
+func WithWAL(appender Appender) CacheOption {
+	return func(options *cacheOptions) {
+		options.wal = appender
+	}
+}
+
+// WithLogger logs mutations to logger, see SetLogger
+func WithLogger(logger Logger) CacheOption {
+	return func(options *cacheOptions) {
+		options.logger = logger
+	}
+}
+
+// NewUsersCacheWithOptions builds a configured cache, NewUsersCache is the zero-config path
+func NewUsersCacheWithOptions(opts ...CacheOption) *UsersCache {
+	var options cacheOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	uc := &UsersCache{
+		initialCapacity: options.initialCapacity,
+		maxUsers:        options.maxUsers,
+		wal:             options.wal,
+		logger:          options.logger,
+		copyOnWrite:     options.copyOnWrite,
+	}
+	if options.lru {
+		uc.lru = newLRUList()
+	}
+	uc.resetLocked()
+	if options.janitorInterval > 0 {
+		uc.StartJanitor(options.janitorInterval, options.janitorMaxIdle)
+	}
+	return uc
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWithInitialCapacity(t *testing.T) {
+	usersCache := NewUsersCacheWithOptions(WithInitialCapacity(1024))
+	if usersCache.initialCapacity != 1024 {
+		t.Fatalf("initialCapacity = %d, want 1024", usersCache.initialCapacity)
+	}
+	usersCache.AddUserData(NewUserData("uid_001", "king", 1, 100))
+	if got := usersCache.Len(); got != 1 {
+		t.Fatalf("Len = %d, want 1", got)
+	}
+}
+
+func TestWithInitialCapacityAfterClear(t *testing.T) {
+	users := make([]*UserData, 4096)
+	for i := range users {
+		users[i] = NewUserData("uid_"+strconv.Itoa(i), "knight", 0, 0)
+	}
+	resets := map[string]func(uc *UsersCache){
+		"Clear": (*UsersCache).Clear,
+		"Drain": func(uc *UsersCache) { uc.Drain() },
+	}
+	for name, reset := range resets {
+		refill := func(uc *UsersCache) func() {
+			return func() {
+				reset(uc)
+				uc.AddUserData(users...)
+			}
+		}
+		presized := testing.AllocsPerRun(5, refill(NewUsersCacheWithOptions(WithInitialCapacity(len(users)))))
+		growing := testing.AllocsPerRun(5, refill(NewUsersCache()))
+		if presized >= growing {
+			t.Fatalf("refill after %s allocated %v times presized, %v without, want fewer presized", name, presized, growing)
+		}
+	}
+}
+
+func TestWithJanitor(t *testing.T) {
+	usersCache := NewUsersCacheWithOptions(WithJanitor(time.Millisecond, time.Minute))
+	defer usersCache.StopJanitor()
+
+	idle := NewUserData("uid_001", "king", 1, 100)
+	usersCache.AddUserData(idle)
+	idle.touchAt(time.Now().Add(-time.Hour))
+
+	deadline := time.Now().Add(time.Second)
+	for usersCache.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("janitor did not evict the idle user")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWithWAL(t *testing.T) {
+	var log bytes.Buffer
+	usersCache := NewUsersCacheWithOptions(WithWAL(NewJSONLinesAppender(&log)))
+	usersCache.AddUserData(NewUserData("uid_001", "king", 1, 100))
+	if log.Len() == 0 {
+		t.Fatal("AddUserData was not journaled")
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "sync"
+
+var userDataPool = sync.Pool{
+	New: func() interface{} { return new(UserData) },
+}
+
+// AcquireUserData returns a zeroed UserData from a pool, fill its fields before
+// sharing it. Pair it with ReleaseUserData to cut allocations in bulk loads
+func AcquireUserData() *UserData {
+	return userDataPool.Get().(*UserData)
+}
+
+// ReleaseUserData zeroes u, including its mutex, callbacks and history, and returns it
+// to the pool. u must not be cached, locked or referenced anywhere afterwards
+func ReleaseUserData(u *UserData) {
+	if u == nil {
+		return
+	}
+	*u = UserData{}
+	userDataPool.Put(u)
+}
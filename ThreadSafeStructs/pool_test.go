@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestReleaseUserDataResets(t *testing.T) {
+	userData := AcquireUserData()
+	userData.UserId = "uid_001"
+	userData.SetExperienceHistorySize(4)
+	userData.AddExperience(100)
+	ReleaseUserData(userData)
+
+	if userData.UserId != "" || userData.GetExperience() != 0 || len(userData.ExperienceHistory()) != 0 {
+		t.Fatalf("released user was not reset: %s", userData.ToApi())
+	}
+}
+
+const benchmarkImportSize = 1024
+
+func BenchmarkBulkImportPlain(b *testing.B) {
+	usersCache := NewUsersCache()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchmarkImportSize; j++ {
+			usersCache.AddUserData(NewUserData("uid_"+strconv.Itoa(j), "player", 0, int64(j)))
+		}
+		usersCache.Drain()
+	}
+}
+
+func BenchmarkBulkImportPooled(b *testing.B) {
+	usersCache := NewUsersCache()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchmarkImportSize; j++ {
+			userData := AcquireUserData()
+			userData.UserId = "uid_" + strconv.Itoa(j)
+			userData.DisplayName = "player"
+			userData.Experience = int64(j)
+			usersCache.AddUserData(userData)
+		}
+		for _, userData := range usersCache.Drain() {
+			ReleaseUserData(userData)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// CacheStats are dashboard aggregates over all cached users
+type CacheStats struct {
+	UserCount       int
+	TotalExperience int64
+	AvgExperience   float64
+	MinLevel        int
+	MaxLevel        int
+}
+
+// Stats computes CacheStats in one pass, an empty cache yields zero values
+func (uc *UsersCache) Stats() CacheStats {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+	var stats CacheStats
+	for _, userData := range uc.userDataById {
+		userData.mu.RLock()
+		level, experience := userData.GameLevel, userData.Experience
+		userData.mu.RUnlock()
+
+		if stats.UserCount == 0 || level < stats.MinLevel {
+			stats.MinLevel = level
+		}
+		if stats.UserCount == 0 || level > stats.MaxLevel {
+			stats.MaxLevel = level
+		}
+		stats.UserCount++
+		stats.TotalExperience += experience
+	}
+	if stats.UserCount > 0 {
+		stats.AvgExperience = float64(stats.TotalExperience) / float64(stats.UserCount)
+	}
+	return stats
+}
+
+// Filter returns copies of users matching pred. pred sees a private copy of each
+// user, so the value it checks is exactly the value returned
+func (uc *UsersCache) Filter(pred func(userData *UserData) bool) []UserData {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+	res := make([]UserData, 0)
+	for _, userData := range uc.userDataById {
+		res = append(res, userData.CopyValue())
+		if !pred(&res[len(res)-1]) {
+			res = res[:len(res)-1]
+		}
+	}
+	return res
+}
+
+// ListPage returns copies of users sorted by UserId, skipping offset and returning at
+// most limit of them. An out of range offset or limit <= 0 returns no users
+func (uc *UsersCache) ListPage(offset, limit int) []UserData {
+	users := uc.GetSnapshot()
+	if offset < 0 || offset >= len(users) || limit <= 0 {
+		return []UserData{}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].UserId < users[j].UserId })
+	end := offset + limit
+	if end > len(users) {
+		end = len(users)
+	}
+	return users[offset:end]
+}
+
+// Keys returns a copy of all cached UserIds taken under a brief read lock, so callers
+// can iterate and look users up one by one without blocking writers
+func (uc *UsersCache) Keys() []string {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+	keys := make([]string, 0, len(uc.userDataById))
+	for userId := range uc.userDataById {
+		keys = append(keys, userId)
+	}
+	return keys
+}
+
+// PickWeightedByExperience picks a random user with probability proportional to its
+// experience, negative experience weighs nothing and an all-zero cache picks uniformly.
+// Candidates are ordered by UserId so a seeded rng gives reproducible picks.
+// Returns false on an empty cache
+func (uc *UsersCache) PickWeightedByExperience(rng *rand.Rand) (*UserData, bool) {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+	if len(uc.userDataById) == 0 {
+		return nil, false
+	}
+	userIds := make([]string, 0, len(uc.userDataById))
+	for userId := range uc.userDataById {
+		userIds = append(userIds, userId)
+	}
+	sort.Strings(userIds)
+
+	weights := make([]float64, len(userIds))
+	total := 0.0
+	for i, userId := range userIds {
+		if experience := uc.userDataById[userId].GetExperience(); experience > 0 {
+			weights[i] = float64(experience)
+			total += weights[i]
+		}
+	}
+	if total == 0 {
+		return uc.userDataById[userIds[rng.Intn(len(userIds))]], true
+	}
+	target := rng.Float64() * total
+	for i, weight := range weights {
+		if target < weight {
+			return uc.userDataById[userIds[i]], true
+		}
+		target -= weight
+	}
+	// Rounding left target past the last weight
+	for i := len(weights) - 1; ; i-- {
+		if weights[i] > 0 {
+			return uc.userDataById[userIds[i]], true
+		}
+	}
+}
+
+// IterByExperience returns an iterator over copies of all users in TopByExperience
+// order. The cache is snapshotted once when iteration starts and no lock is held
+// while the caller consumes the values. Copies are yielded by pointer because
+// UserData holds a mutex, mutating them does not affect the cache
+func (uc *UsersCache) IterByExperience() func(yield func(*UserData) bool) {
+	return func(yield func(*UserData) bool) {
+		snapshot := uc.GetSnapshot()
+		sortByExperience(snapshot)
+		for i := range snapshot {
+			if !yield(&snapshot[i]) {
+				return
+			}
+		}
+	}
+}
+
+// ModifiedSince returns copies of the users whose UpdatedAt is after since, sorted by
+// UserId, for incremental sync
+func (uc *UsersCache) ModifiedSince(since time.Time) []UserData {
+	uc.mu.RLock()
+	res := make([]UserData, 0)
+	for _, userData := range uc.userDataById {
+		if userData.GetUpdatedAt().After(since) {
+			res = append(res, userData.CopyValue())
+		}
+	}
+	uc.mu.RUnlock()
+	sort.Slice(res, func(i, j int) bool { return res[i].UserId < res[j].UserId })
+	return res
+}
+
+// MaxByExperience returns a copy of the user with the most experience in one pass,
+// ties go to the lowest UserId. It returns false on an empty cache
+func (uc *UsersCache) MaxByExperience() (UserData, bool) {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+	var (
+		best           *UserData
+		bestId         string
+		bestExperience int64
+	)
+	for userId, userData := range uc.userDataById {
+		experience := userData.experience()
+		if best == nil || experience > bestExperience || (experience == bestExperience && userId < bestId) {
+			best, bestId, bestExperience = userData, userId, experience
+		}
+	}
+	if best == nil {
+		return UserData{}, false
+	}
+	return best.CopyValue(), true
+}
+
+// GroupCount counts users per key(user). key runs under the cache read lock without the
+// user lock, so it reads through the locking getters
+func (uc *UsersCache) GroupCount(key func(userData *UserData) string) map[string]int {
+	uc.mu.RLock()
+	defer uc.mu.RUnlock()
+	counts := make(map[string]int)
+	for _, userData := range uc.userDataById {
+		counts[key(userData)]++
+	}
+	return counts
+}
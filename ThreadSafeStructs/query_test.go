@@ -0,0 +1,200 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	usersCache := NewUsersCache()
+	if got := usersCache.Stats(); got != (CacheStats{}) {
+		t.Fatalf("empty cache Stats = %+v, want zero value", got)
+	}
+
+	usersCache.AddUserData(
+		NewUserData("uid_001", "king", 1, 100),
+		NewUserData("uid_002", "queen", 2, 200),
+		NewUserData("uid_003", "soldier", 0, 50),
+		NewUserData("uid_004", "John", 5, 550),
+	)
+	want := CacheStats{
+		UserCount:       4,
+		TotalExperience: 900,
+		AvgExperience:   225,
+		MinLevel:        0,
+		MaxLevel:        5,
+	}
+	if got := usersCache.Stats(); got != want {
+		t.Fatalf("Stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	usersCache.AddUserData(NewUserData("uid_005", "knight", 5, 520), NewUserData("uid_006", "John", 7, 700))
+
+	highLevel := usersCache.Filter(func(userData *UserData) bool {
+		return userData.GetGameLevel() >= 5
+	})
+	if got := sortedIds(highLevel); !reflect.DeepEqual(got, []string{"uid_005", "uid_006"}) {
+		t.Fatalf("level >= 5 = %v", got)
+	}
+
+	notJohn := usersCache.Filter(excludeJohnFilter)
+	if got := sortedIds(notJohn); !reflect.DeepEqual(got, []string{"uid_001", "uid_002", "uid_003", "uid_005"}) {
+		t.Fatalf("excludeJohnFilter = %v", got)
+	}
+}
+
+func TestListPage(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	tests := []struct {
+		offset, limit int
+		want          []string
+	}{
+		{offset: 0, limit: 2, want: []string{"uid_001", "uid_002"}},
+		{offset: 2, limit: 2, want: []string{"uid_003", "uid_004"}},
+		{offset: 3, limit: 10, want: []string{"uid_004"}},
+		{offset: 4, limit: 2, want: []string{}},
+		{offset: -1, limit: 2, want: []string{}},
+		{offset: 0, limit: 0, want: []string{}},
+	}
+	for _, tt := range tests {
+		for call := 0; call < 3; call++ {
+			got := userIds(usersCache.ListPage(tt.offset, tt.limit))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ListPage(%d, %d) call %d = %v, want %v", tt.offset, tt.limit, call, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	keys := usersCache.Keys()
+	sort.Strings(keys)
+	want := []string{"uid_001", "uid_002", "uid_003", "uid_004"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("Keys = %v, want %v", keys, want)
+	}
+
+	usersCache.AddUserData(NewUserData("uid_005", "knight", 0, 0))
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("returned keys changed after AddUserData: %v", keys)
+	}
+}
+
+func TestPickWeightedByExperience(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	if _, found := NewUsersCache().PickWeightedByExperience(rng); found {
+		t.Fatal("pick from empty cache returned found")
+	}
+
+	usersCache := NewUsersCache()
+	usersCache.AddUserData(
+		NewUserData("uid_001", "king", 0, 1),
+		NewUserData("uid_002", "queen", 0, 0),
+		NewUserData("uid_003", "whale", 0, 1000000),
+	)
+	picks := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		userData, found := usersCache.PickWeightedByExperience(rng)
+		if !found {
+			t.Fatal("pick returned not found")
+		}
+		picks[userData.GetUserId()]++
+	}
+	if picks["uid_003"] != 100 {
+		t.Fatalf("picks = %v, want all uid_003", picks)
+	}
+
+	first, _ := usersCache.PickWeightedByExperience(rand.New(rand.NewSource(7)))
+	again, _ := usersCache.PickWeightedByExperience(rand.New(rand.NewSource(7)))
+	if first != again {
+		t.Fatal("same seed picked different users")
+	}
+}
+
+func TestIterByExperience(t *testing.T) {
+	usersCache := newLoadedCache(t)
+
+	var ids []string
+	usersCache.IterByExperience()(func(userData *UserData) bool {
+		ids = append(ids, userData.UserId)
+		return true
+	})
+	want := []string{"uid_003", "uid_004", "uid_002", "uid_001"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Fatalf("ids = %v, want %v", ids, want)
+	}
+
+	ids = nil
+	for userData := range usersCache.IterByExperience() {
+		ids = append(ids, userData.UserId)
+		if len(ids) == 2 {
+			break
+		}
+	}
+	if !reflect.DeepEqual(ids, want[:2]) {
+		t.Fatalf("ids after break = %v, want %v", ids, want[:2])
+	}
+
+	for userData := range usersCache.IterByExperience() {
+		userData.Experience = 0
+	}
+	if userData, _ := usersCache.GetUserData("uid_003"); userData.GetExperience() != 120 {
+		t.Fatalf("mutating a yielded copy changed the cache: experience = %d", userData.GetExperience())
+	}
+}
+
+func TestModifiedSince(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	since := time.Now()
+	usersCache.UpdateUserData("uid_002", func(u *UserData) { u.Experience = 200 })
+	_, _ = usersCache.CacheAddExperience("uid_004", 10)
+
+	if got, want := userIds(usersCache.ModifiedSince(since)), []string{"uid_002", "uid_004"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ModifiedSince = %v, want %v", got, want)
+	}
+	if got := usersCache.ModifiedSince(time.Now()); len(got) != 0 {
+		t.Fatalf("ModifiedSince(now) = %v, want empty", userIds(got))
+	}
+}
+
+func TestMaxByExperience(t *testing.T) {
+	usersCache := NewUsersCache()
+	if _, found := usersCache.MaxByExperience(); found {
+		t.Fatal("MaxByExperience on empty cache returned true")
+	}
+
+	usersCache = newLoadedCache(t)
+	best, found := usersCache.MaxByExperience()
+	if !found || best.UserId != "uid_003" || best.Experience != 120 {
+		t.Fatalf("MaxByExperience = %s, %v, want uid_003 winning the tie with uid_004", best.UserId, found)
+	}
+
+	_, _ = usersCache.CacheAddExperience("uid_001", 100)
+	if best, _ := usersCache.MaxByExperience(); best.UserId != "uid_001" {
+		t.Fatalf("MaxByExperience = %s, want uid_001", best.UserId)
+	}
+}
+
+func TestGroupCount(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	usersCache.AddUserData(NewUserData("uid_005", "squire", 0, 5000))
+
+	byTier := usersCache.GroupCount((*UserData).Tier)
+	if want := map[string]int{"Bronze": 4, "Gold": 1}; !reflect.DeepEqual(byTier, want) {
+		t.Fatalf("by tier = %v, want %v", byTier, want)
+	}
+
+	byInitial := usersCache.GroupCount(func(userData *UserData) string {
+		return userData.GetDisplayName()[:1]
+	})
+	if want := map[string]int{"k": 1, "q": 1, "s": 2, "J": 1}; !reflect.DeepEqual(byInitial, want) {
+		t.Fatalf("by first letter = %v, want %v", byInitial, want)
+	}
+}
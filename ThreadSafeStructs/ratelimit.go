@@ -0,0 +1,39 @@
+package main
+
+import "time"
+
+// experienceWindow tracks the experience granted by TryAddExperience in the current window
+type experienceWindow struct {
+	start   time.Time
+	granted int64
+}
+
+// TryAddExperience adds up to delta experience while keeping the total granted within
+// window at or below maxPerWindow. The window starts at the first gain and rolls over
+// once now is window past its start. It returns the experience actually applied and
+// false when the budget is exhausted. Non-positive deltas are not gains and are applied
+// in full without touching the budget
+func (u *UserData) TryAddExperience(delta int64, now time.Time, maxPerWindow int64, window time.Duration) (applied int64, ok bool) {
+	u.lock()
+	if delta > 0 {
+		if u.gainWindow.start.IsZero() || !now.Before(u.gainWindow.start.Add(window)) {
+			u.gainWindow = experienceWindow{start: now}
+		}
+		remaining := maxPerWindow - u.gainWindow.granted
+		if remaining <= 0 {
+			u.mu.Unlock()
+			return 0, false
+		}
+		if delta > remaining {
+			delta = remaining
+		}
+		u.gainWindow.granted += delta
+	}
+	u.touchAt(now)
+	_, levelUp, change := u.addExperienceLocked(delta)
+	u.mu.Unlock()
+
+	levelUp.notify()
+	change.notify()
+	return delta, true
+}
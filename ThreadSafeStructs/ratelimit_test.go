@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTryAddExperience(t *testing.T) {
+	userData := NewUserData("uid_001", "king", 0, 0)
+	start := time.Unix(1700000000, 0)
+	const maxPerWindow = 100
+
+	steps := []struct {
+		name        string
+		delta       int64
+		at          time.Duration
+		wantApplied int64
+		wantOk      bool
+	}{
+		{"within budget", 60, 0, 60, true},
+		{"partial grant", 60, 10 * time.Second, 40, true},
+		{"exhausted", 10, 30 * time.Second, 0, false},
+		{"just before rollover", 10, 59 * time.Second, 0, false},
+		{"rolled over", 30, time.Minute, 30, true},
+	}
+	for _, step := range steps {
+		applied, ok := userData.TryAddExperience(step.delta, start.Add(step.at), maxPerWindow, time.Minute)
+		if applied != step.wantApplied || ok != step.wantOk {
+			t.Fatalf("%s: TryAddExperience = (%d, %v), want (%d, %v)", step.name, applied, ok, step.wantApplied, step.wantOk)
+		}
+	}
+	if got := userData.GetExperience(); got != 130 {
+		t.Fatalf("experience = %d, want 130", got)
+	}
+	if got := userData.GetGameLevel(); got != LevelCurve(130) {
+		t.Fatalf("level = %d, want %d", got, LevelCurve(130))
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RunInterval is how often Run repeats the workload
+var RunInterval = time.Second
+
+// Run performs the periodic cache workload every RunInterval until ctx is cancelled,
+// then returns nil
+func Run(ctx context.Context, cache *UsersCache) error {
+	if cache == nil {
+		return errors.New("run: nil cache")
+	}
+	ticker := time.NewTicker(RunInterval)
+	defer ticker.Stop()
+	for {
+		runWorkload(cache)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// runWorkload runs one bounded round of concurrent reads and updates and prints the level counts
+func runWorkload(cache *UsersCache) {
+	cache.RunWorkers(100, func(uc *UsersCache) {
+		uc.PerformReadOperation(func(userData *UserData) {
+			userData.ToApi()
+			userData.AddExperience(10)
+		})
+		uc.WithUser("uid_001", func(u *UserData) {
+			u.SetExperience(199)
+		})
+		uc.WithUser("uid_001", func(u *UserData) {
+			u.AddExperience(10)
+		})
+	})
+
+	levelCounts := cache.MapReduceUsersWithFilter(excludeJohnFilter, userLevelMapper, levelCountReducer)
+
+	for level, count := range levelCounts.(map[int]int) {
+		fmt.Printf("Level %d: %d users\n", level, count)
+	}
+}
+
+// RunWorkers runs task on n goroutines and returns once all of them have finished.
+// It does nothing when n is not positive
+func (uc *UsersCache) RunWorkers(n int, task func(*UsersCache)) {
+	if n <= 0 {
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			task(uc)
+		}()
+	}
+	wg.Wait()
+}
+
+// workloadMixRatio is how many operations the workloads run per minority operation
+const workloadMixRatio = 10
+
+// RunReadHeavyWorkload performs iterations operations on GOMAXPROCS workers, one
+// CacheAddExperience for every nine GetUserData lookups, spread over the cached users.
+// It is meant for benchmarks and profiles of lock contention
+func RunReadHeavyWorkload(cache *UsersCache, iterations int) {
+	runMixedWorkload(cache, iterations, func(i int) bool { return i%workloadMixRatio == 0 })
+}
+
+// RunWriteHeavyWorkload is RunReadHeavyWorkload with the mix reversed, nine
+// CacheAddExperience calls for every lookup
+func RunWriteHeavyWorkload(cache *UsersCache, iterations int) {
+	runMixedWorkload(cache, iterations, func(i int) bool { return i%workloadMixRatio != 0 })
+}
+
+func runMixedWorkload(cache *UsersCache, iterations int, isWrite func(i int) bool) {
+	userIds := cache.Keys()
+	if len(userIds) == 0 {
+		return
+	}
+	var next int64
+	cache.RunWorkers(runtime.GOMAXPROCS(0), func(uc *UsersCache) {
+		for {
+			i := int(atomic.AddInt64(&next, 1) - 1)
+			if i >= iterations {
+				return
+			}
+			userId := userIds[i%len(userIds)]
+			if isWrite(i) {
+				_, _ = uc.CacheAddExperience(userId, 1)
+			} else if userData, found := uc.GetUserData(userId); found {
+				userData.GetSnapshotFields()
+			}
+		}
+	})
+}
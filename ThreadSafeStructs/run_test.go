@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRunReturnsOnCancel(t *testing.T) {
+	defer func(interval time.Duration) { RunInterval = interval }(RunInterval)
+	RunInterval = 10 * time.Millisecond
+
+	usersCache := newLoadedCache(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, usersCache) }()
+
+	time.Sleep(30 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run returned %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+func TestRunNilCache(t *testing.T) {
+	if err := Run(context.Background(), nil); err == nil {
+		t.Fatal("Run with nil cache returned nil error")
+	}
+}
+
+func TestRunWorkers(t *testing.T) {
+	usersCache := NewUsersCache()
+	usersCache.AddUserData(NewUserData("uid_001", "king", 0, 0))
+
+	const workers, delta = 10, 7
+	usersCache.RunWorkers(workers, func(uc *UsersCache) {
+		uc.WithUser("uid_001", func(u *UserData) {
+			u.AddExperience(delta)
+		})
+	})
+
+	userData, _ := usersCache.GetUserData("uid_001")
+	if got := userData.GetExperience(); got != workers*delta {
+		t.Fatalf("experience = %d, want %d", got, workers*delta)
+	}
+}
+
+func TestRunWorkersWithoutWorkers(t *testing.T) {
+	usersCache := NewUsersCache()
+	for _, n := range []int{0, -1} {
+		usersCache.RunWorkers(n, func(*UsersCache) {
+			t.Errorf("RunWorkers(%d) ran the task", n)
+		})
+	}
+}
+
+func TestMixedWorkloads(t *testing.T) {
+	tests := []struct {
+		name       string
+		workload   func(cache *UsersCache, iterations int)
+		wantGained int64
+	}{
+		{"read heavy", RunReadHeavyWorkload, 10},
+		{"write heavy", RunWriteHeavyWorkload, 90},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usersCache := newLoadedCache(t)
+			before := usersCache.Stats().TotalExperience
+			tt.workload(usersCache, 100)
+			if gained := usersCache.Stats().TotalExperience - before; gained != tt.wantGained {
+				t.Fatalf("experience gained = %d, want %d", gained, tt.wantGained)
+			}
+		})
+	}
+}
+
+func BenchmarkReadHeavyWorkload(b *testing.B) {
+	usersCache := NewUsersCache()
+	usersCache.AddUserData(benchmarkUsers(1024)...)
+	b.ResetTimer()
+	RunReadHeavyWorkload(usersCache, b.N)
+}
+
+func BenchmarkWriteHeavyWorkload(b *testing.B) {
+	usersCache := NewUsersCache()
+	usersCache.AddUserData(benchmarkUsers(1024)...)
+	b.ResetTimer()
+	RunWriteHeavyWorkload(usersCache, b.N)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// DefaultShardCount is used by NewShardedUsersCache when a non-positive count is passed
+const DefaultShardCount = 32
+
+type usersShard struct {
+	mu           sync.RWMutex
+	userDataById map[string]*UserData
+}
+
+// ShardedUsersCache spreads users over independently locked shards so inserts
+// for different users rarely contend, users are routed by FNV hash of UserId
+type ShardedUsersCache struct {
+	shards []*usersShard
+}
+
+func NewShardedUsersCache(shardCount int) *ShardedUsersCache {
+	if shardCount <= 0 {
+		shardCount = DefaultShardCount
+	}
+	shards := make([]*usersShard, shardCount)
+	for i := range shards {
+		shards[i] = &usersShard{userDataById: make(map[string]*UserData)}
+	}
+	return &ShardedUsersCache{shards: shards}
+}
+
+func (sc *ShardedUsersCache) shardFor(userId string) *usersShard {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(userId))
+	return sc.shards[hash.Sum32()%uint32(len(sc.shards))]
+}
+
+func (sc *ShardedUsersCache) GetUserData(userId string) (*UserData, bool) {
+	shard := sc.shardFor(userId)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	userData, found := shard.userDataById[userId]
+	return userData, found
+}
+
+func (sc *ShardedUsersCache) AddUserData(users ...*UserData) {
+	for _, user := range users {
+		userId := user.GetUserId()
+		shard := sc.shardFor(userId)
+		shard.mu.Lock()
+		shard.userDataById[userId] = user
+		shard.mu.Unlock()
+	}
+}
+
+func (sc *ShardedUsersCache) RemoveUserData(userId string) bool {
+	shard := sc.shardFor(userId)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	_, found := shard.userDataById[userId]
+	delete(shard.userDataById, userId)
+	return found
+}
+
+// Len sums the shard sizes, shards are locked one at a time so the total is
+// not a consistent snapshot under concurrent writes
+func (sc *ShardedUsersCache) Len() int {
+	total := 0
+	for _, shard := range sc.shards {
+		shard.mu.RLock()
+		total += len(shard.userDataById)
+		shard.mu.RUnlock()
+	}
+	return total
+}
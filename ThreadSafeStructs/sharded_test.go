@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+)
+
+func TestShardedUsersCache(t *testing.T) {
+	shardedCache := NewShardedUsersCache(4)
+	for i := 0; i < 100; i++ {
+		shardedCache.AddUserData(NewUserData(fmt.Sprintf("uid_%03d", i), "player", 0, 0))
+	}
+	if got := shardedCache.Len(); got != 100 {
+		t.Fatalf("Len = %d, want 100", got)
+	}
+	if _, found := shardedCache.GetUserData("uid_042"); !found {
+		t.Fatal("uid_042 not found")
+	}
+	if !shardedCache.RemoveUserData("uid_042") {
+		t.Fatal("RemoveUserData(uid_042) = false, want true")
+	}
+	if shardedCache.RemoveUserData("uid_042") {
+		t.Fatal("second RemoveUserData(uid_042) = true, want false")
+	}
+	if got := shardedCache.Len(); got != 99 {
+		t.Fatalf("Len after remove = %d, want 99", got)
+	}
+}
+
+func benchmarkUsers(n int) []*UserData {
+	users := make([]*UserData, n)
+	for i := range users {
+		users[i] = NewUserData(fmt.Sprintf("uid_%d", i), "player", 0, 0)
+	}
+	return users
+}
+
+func BenchmarkParallelAddUserDataSingleMutex(b *testing.B) {
+	users := benchmarkUsers(1 << 16)
+	usersCache := NewUsersCache()
+	var next uint64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddUint64(&next, 1)
+			usersCache.AddUserData(users[i%uint64(len(users))])
+		}
+	})
+}
+
+func BenchmarkParallelAddUserDataSharded(b *testing.B) {
+	users := benchmarkUsers(1 << 16)
+	shardedCache := NewShardedUsersCache(DefaultShardCount)
+	var next uint64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddUint64(&next, 1)
+			shardedCache.AddUserData(users[i%uint64(len(users))])
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Tier names an experience bracket
+type Tier string
+
+const (
+	TierBronze Tier = "Bronze"
+	TierSilver Tier = "Silver"
+	TierGold   Tier = "Gold"
+)
+
+// TierThreshold is the minimum experience needed to reach Tier
+type TierThreshold struct {
+	Tier          Tier
+	MinExperience int64
+}
+
+// DefaultTierThresholds are the thresholds used until SetTierThresholds is called
+var DefaultTierThresholds = []TierThreshold{
+	{TierBronze, 0},
+	{TierSilver, 1000},
+	{TierGold, 5000},
+}
+
+var (
+	tiersMu        sync.RWMutex
+	tierThresholds = DefaultTierThresholds
+)
+
+// SetTierThresholds replaces the thresholds used by Tier and CountByTier. They must
+// be in strictly ascending MinExperience order with non-empty names, otherwise the
+// current thresholds are kept and an error is returned
+func SetTierThresholds(thresholds []TierThreshold) error {
+	if err := validateTierThresholds(thresholds); err != nil {
+		return err
+	}
+	copied := make([]TierThreshold, len(thresholds))
+	copy(copied, thresholds)
+	tiersMu.Lock()
+	defer tiersMu.Unlock()
+	tierThresholds = copied
+	return nil
+}
+
+func validateTierThresholds(thresholds []TierThreshold) error {
+	if len(thresholds) == 0 {
+		return errors.New("tier thresholds: empty")
+	}
+	for i, threshold := range thresholds {
+		if threshold.Tier == "" {
+			return fmt.Errorf("tier thresholds: empty name at index %d", i)
+		}
+		if i > 0 && threshold.MinExperience <= thresholds[i-1].MinExperience {
+			return fmt.Errorf("tier thresholds: %s at %d is not above %s at %d",
+				threshold.Tier, threshold.MinExperience, thresholds[i-1].Tier, thresholds[i-1].MinExperience)
+		}
+	}
+	return nil
+}
+
+// tierFor returns the highest tier whose threshold experience reaches, "" below the lowest
+func tierFor(experience int64) Tier {
+	tiersMu.RLock()
+	defer tiersMu.RUnlock()
+	var tier Tier
+	for _, threshold := range tierThresholds {
+		if experience < threshold.MinExperience {
+			break
+		}
+		tier = threshold.Tier
+	}
+	return tier
+}
+
+// Tier returns the name of the user's tier for their experience, empty when it is
+// below the lowest threshold
+func (u *UserData) Tier() string {
+	return string(tierFor(u.GetExperience()))
+}
+
+// CountByTier returns how many users are in each tier, users below the lowest
+// threshold are counted under ""
+func (uc *UsersCache) CountByTier() map[string]int {
+	return uc.GroupCount((*UserData).Tier)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCountByTier(t *testing.T) {
+	defer func() { _ = SetTierThresholds(DefaultTierThresholds) }()
+	if err := SetTierThresholds([]TierThreshold{
+		{TierBronze, 100},
+		{TierSilver, 110},
+		{TierGold, 120},
+	}); err != nil {
+		t.Fatalf("SetTierThresholds: %v", err)
+	}
+
+	usersCache := newLoadedCache(t)
+	usersCache.AddUserData(NewUserData("uid_005", "peasant", 0, 50))
+	want := map[string]int{"": 1, "Bronze": 1, "Silver": 1, "Gold": 2}
+	if got := usersCache.CountByTier(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("CountByTier = %v, want %v", got, want)
+	}
+
+	userData, _ := usersCache.GetUserData("uid_002")
+	if got := userData.Tier(); got != "Silver" {
+		t.Fatalf("uid_002 tier = %q, want Silver", got)
+	}
+}
+
+func TestSetTierThresholdsRejectsUnordered(t *testing.T) {
+	defer func() { _ = SetTierThresholds(DefaultTierThresholds) }()
+	tests := map[string][]TierThreshold{
+		"empty":      nil,
+		"descending": {{TierGold, 5000}, {TierBronze, 0}},
+		"duplicate":  {{TierBronze, 0}, {TierSilver, 0}},
+		"no name":    {{"", 0}},
+	}
+	for name, thresholds := range tests {
+		if err := SetTierThresholds(thresholds); err == nil {
+			t.Fatalf("%s: SetTierThresholds returned nil error", name)
+		}
+	}
+	if got := NewUserData("uid_001", "king", 0, 1000).Tier(); got != "Silver" {
+		t.Fatalf("tier after rejected thresholds = %q, want Silver from the defaults", got)
+	}
+}
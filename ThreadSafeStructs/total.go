@@ -0,0 +1,29 @@
+package main
+
+import "sync/atomic"
+
+// TotalExperience returns the summed experience of all cached users in O(1). The sum is
+// kept by cache methods: inserts, removals and the experience updates that keep the
+// level index in sync, such as CacheAddExperience, UpdateUserData, BatchUpdate and
+// Transfer. Changes made through UserData methods like SetExperience on a cached user,
+// or inside WithUser, are not counted until a cache method touches that user again
+func (uc *UsersCache) TotalExperience() int64 {
+	return atomic.LoadInt64(&uc.totalExperience)
+}
+
+// trackExperienceLocked records userId's current experience and adds the change to the
+// total, the caller holds the cache write lock
+func (uc *UsersCache) trackExperienceLocked(userId string, experience int64) {
+	previous := uc.experienceById[userId]
+	uc.experienceById[userId] = experience
+	atomic.AddInt64(&uc.totalExperience, experience-previous)
+}
+
+func (uc *UsersCache) untrackExperienceLocked(userId string) {
+	previous, found := uc.experienceById[userId]
+	if !found {
+		return
+	}
+	delete(uc.experienceById, userId)
+	atomic.AddInt64(&uc.totalExperience, -previous)
+}
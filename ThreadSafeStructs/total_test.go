@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestTotalExperience(t *testing.T) {
+	usersCache := newLoadedCache(t)
+	steps := []struct {
+		name   string
+		mutate func()
+	}{
+		{"load", func() {}},
+		{"add", func() { usersCache.AddUserData(NewUserData("uid_005", "knight", 0, 500)) }},
+		{"replace", func() { usersCache.AddUserData(NewUserData("uid_005", "knight", 0, 50)) }},
+		{"cache add experience", func() { _, _ = usersCache.CacheAddExperience("uid_001", 25) }},
+		{"update", func() { usersCache.UpdateUserData("uid_002", func(u *UserData) { u.Experience = 10 }) }},
+		{"transfer", func() { _ = usersCache.Transfer("uid_003", "uid_004", 20) }},
+		{"decay", func() { _ = usersCache.DecayAll(0.5) }},
+		{"remove", func() { usersCache.RemoveUserData("uid_004") }},
+		{"clear", usersCache.Clear},
+	}
+	for _, step := range steps {
+		step.mutate()
+		if got, want := usersCache.TotalExperience(), usersCache.Stats().TotalExperience; got != want {
+			t.Fatalf("after %s: TotalExperience = %d, want %d", step.name, got, want)
+		}
+	}
+}
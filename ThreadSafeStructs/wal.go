@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Operations journaled to the write-ahead log
+const (
+	WALOpAdd    = "add"
+	WALOpUpdate = "update"
+	WALOpRemove = "remove"
+)
+
+// Appender journals cache mutations. It is called while the cache lock is held,
+// possibly from several goroutines at once for updates, so it must be safe for
+// concurrent use and must not call back into the cache
+type Appender interface {
+	Append(op string, userId string, payload []byte) error
+}
+
+// SetWAL enables journaling of every add and remove, see SetLogger, and of
+// UpdateUserData, nil disables it. Other field updates are not journaled.
+// Append errors can't be returned by those methods and are dropped, appenders that
+// must not lose entries have to handle failures themselves
+func (uc *UsersCache) SetWAL(appender Appender) {
+	uc.mu.Lock()
+	defer uc.unlock()
+	uc.wal = appender
+}
+
+// appendWALLocked journals op with the user's current state as payload, caller holds uc.mu
+func (uc *UsersCache) appendWALLocked(op string, userId string, userData *UserData) {
+	if uc.wal == nil {
+		return
+	}
+	var payload []byte
+	if userData != nil {
+		payload, _ = json.Marshal(userData.jsonSnapshot())
+	}
+	_ = uc.wal.Append(op, userId, payload)
+}
+
+// appendUpdateLocked journals an update with the user's state as payload. The caller
+// holds uc.mu for reading and the user's write lock, so concurrent updates to one user
+// reach the Appender in the order they were applied
+func (uc *UsersCache) appendUpdateLocked(userId string, userData *UserData) {
+	if uc.wal == nil {
+		return
+	}
+	payload, _ := json.Marshal(userData.jsonSnapshotLocked())
+	_ = uc.wal.Append(WALOpUpdate, userId, payload)
+}
+
+type walEntry struct {
+	Op      string          `json:"op"`
+	UserId  string          `json:"uid"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// JSONLinesAppender writes one JSON entry per line, the format read by ReplayWAL
+type JSONLinesAppender struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewJSONLinesAppender(w io.Writer) *JSONLinesAppender {
+	return &JSONLinesAppender{w: w}
+}
+
+func (a *JSONLinesAppender) Append(op string, userId string, payload []byte) error {
+	line, err := json.Marshal(walEntry{Op: op, UserId: userId, Payload: payload})
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.w.Write(append(line, '\n'))
+	return err
+}
+
+// ReplayWAL applies a log written by JSONLinesAppender to cache. Replayed entries
+// are not journaled again to the cache's own WAL
+func ReplayWAL(cache *UsersCache, r io.Reader) error {
+	cache.mu.Lock()
+	defer cache.unlock()
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		var entry walEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("wal line %d: %w", line, err)
+		}
+		switch entry.Op {
+		case WALOpAdd, WALOpUpdate:
+			var snapshot userDataJSON
+			if err := json.Unmarshal(entry.Payload, &snapshot); err != nil {
+				return fmt.Errorf("wal line %d: %w", line, err)
+			}
+			cache.insertLocked(entry.UserId, snapshot.userData())
+		case WALOpRemove:
+			cache.deleteLocked(entry.UserId)
+		default:
+			return fmt.Errorf("wal line %d: unknown op %q", line, entry.Op)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestReplayWAL(t *testing.T) {
+	var log bytes.Buffer
+	usersCache := NewUsersCache()
+	usersCache.SetWAL(NewJSONLinesAppender(&log))
+
+	if err := LoadUsersDataFromDB(usersCache, MockUserSource{}); err != nil {
+		t.Fatalf("LoadUsersDataFromDB: %v", err)
+	}
+	usersCache.RemoveUserData("uid_004")
+	usersCache.UpdateUserData("uid_001", func(userData *UserData) {
+		userData.DisplayName = "emperor"
+		userData.Experience = 999
+	})
+	usersCache.RemoveUserData("uid_404")
+
+	replayed := NewUsersCache()
+	if err := ReplayWAL(replayed, &log); err != nil {
+		t.Fatalf("ReplayWAL: %v", err)
+	}
+	want, _ := usersCache.ExportJSON()
+	got, _ := replayed.ExportJSON()
+	if !bytes.Equal(got, want) {
+		t.Fatalf("replayed cache = %s, want %s", got, want)
+	}
+}
+
+func TestReplayWALUnknownOp(t *testing.T) {
+	log := bytes.NewBufferString(`{"op":"explode","uid":"uid_001"}` + "\n")
+	if err := ReplayWAL(NewUsersCache(), log); err == nil {
+		t.Fatal("ReplayWAL returned nil error for an unknown op")
+	}
+}
+
+// slowAppender holds back the entry whose payload contains slow until the next
+// update has had a chance to run, signalling entered when it starts waiting
+type slowAppender struct {
+	next    Appender
+	slow    []byte
+	entered chan struct{}
+}
+
+func (a *slowAppender) Append(op string, userId string, payload []byte) error {
+	if bytes.Contains(payload, a.slow) {
+		close(a.entered)
+		time.Sleep(50 * time.Millisecond)
+	}
+	return a.next.Append(op, userId, payload)
+}
+
+func TestUpdateUserDataJournalsInApplyOrder(t *testing.T) {
+	var log bytes.Buffer
+	usersCache := NewUsersCache()
+	usersCache.AddUserData(NewUserData("uid_001", "king", 0, 0))
+	appender := &slowAppender{next: NewJSONLinesAppender(&log), slow: []byte(`"experience":1,`), entered: make(chan struct{})}
+	usersCache.SetWAL(appender)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		usersCache.UpdateUserData("uid_001", func(u *UserData) { u.Experience = 1 })
+	}()
+	<-appender.entered
+	usersCache.UpdateUserData("uid_001", func(u *UserData) { u.Experience = 2 })
+	<-done
+
+	replayed := NewUsersCache()
+	if err := ReplayWAL(replayed, &log); err != nil {
+		t.Fatalf("ReplayWAL: %v", err)
+	}
+	userData, _ := replayed.GetUserData("uid_001")
+	if got := userData.GetExperience(); got != 2 {
+		t.Fatalf("replayed experience = %d, want 2", got)
+	}
+}